@@ -1,7 +1,7 @@
 package client
 
 import (
-	"fmt"
+	"net"
 	"time"
 
 	"github.com/xkeyideal/ThriftClientPool/thriftPool"
@@ -12,24 +12,36 @@ import (
 
 var GlobalRpcPool *thriftPool.ThriftPool
 
-func Dial(addr, port string, connTimeout time.Duration) (*thriftPool.IdleClient, error) {
-	socket, err := thrift.NewTSocketTimeout(fmt.Sprintf("%s:%s", addr, port), connTimeout)
+// 按服务名配置的连接池, 由LoadPools加载
+var Pools *thriftPool.Registry
+
+var clientFactories = map[string]thriftPool.ClientFactory{
+	"rpc": func(t thrift.TTransport, f thrift.TProtocolFactory) interface{} {
+		return tutorial.NewRpcServiceClientFactory(t, f)
+	},
+}
+
+func LoadPools(path string) error {
+	registry, err := thriftPool.LoadRegistry(path, clientFactories)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	transportFactory := thrift.NewTFramedTransportFactory(thrift.NewTTransportFactory())
-	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
-	client := tutorial.NewRpcServiceClientFactory(transportFactory.GetTransport(socket), protocolFactory)
+	Pools = registry
+	return nil
+}
 
-	err = client.Transport.Open()
+func Dial(addr, port string, connTimeout time.Duration) (*thriftPool.IdleClient, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, port), connTimeout)
 	if err != nil {
 		return nil, err
 	}
-
-	return &thriftPool.IdleClient{
-		Client: client,
-		Socket: socket,
-	}, nil
+	//读写超时由连接池分别设置, 连接已建立, 不需要再Open
+	socket := thriftPool.NewTimeoutSocket(conn)
+	transportFactory := thrift.NewTFramedTransportFactory(thrift.NewTTransportFactory())
+	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
+	c := &thriftPool.IdleClient{Socket: socket}
+	c.Client = tutorial.NewRpcServiceClientFactory(transportFactory.GetTransport(socket), c.TrackProtocol(protocolFactory))
+	return c, nil
 }
 
 func Close(c *thriftPool.IdleClient) error {
@@ -18,6 +18,7 @@ func mainServer() {
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	<-signals
 
+	server.StopServer()
 	os.Exit(0)
 }
 
@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xkeyideal/ThriftClientPool/thriftPool"
+)
+
+// 注册表的HTTP客户端, Addr形如 http://127.0.0.1:23457
+type Client struct {
+	Addr string
+	HTTP *http.Client
+}
+
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		Addr: strings.TrimSuffix(addr, "/"),
+		HTTP: &http.Client{Timeout: MAXWATCHWAIT + 10*time.Second},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.Addr+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("registry %s %s error, status:%s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// 注册与续约使用同一接口
+func (c *Client) Register(ctx context.Context, service string, target thriftPool.Target) error {
+	return c.do(ctx, http.MethodPut, "/services/"+url.PathEscape(service)+"/instances", target, nil)
+}
+
+func (c *Client) Deregister(ctx context.Context, service string, target thriftPool.Target) error {
+	return c.do(ctx, http.MethodDelete,
+		"/services/"+url.PathEscape(service)+"/instances/"+url.PathEscape(target.Addr()), nil, nil)
+}
+
+// wait为0时立即返回, 否则阻塞直到注册表index大于index或wait超时
+func (c *Client) Service(ctx context.Context, service string, index uint64, wait time.Duration) (Service, error) {
+	var s Service
+	path := "/services/" + url.PathEscape(service)
+	if wait > 0 {
+		path += fmt.Sprintf("?index=%d&wait=%s", index, wait)
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &s)
+	return s, err
+}
+
+// 在后台按interval续约的服务实例, Stop时注销
+type Agent struct {
+	client  *Client
+	service string
+	target  thriftPool.Target
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func StartAgent(client *Client, service string, target thriftPool.Target, interval time.Duration) *Agent {
+	if interval <= 0 {
+		interval = DEFAULTTTL / 3
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Agent{
+		client:  client,
+		service: service,
+		target:  target,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			//注册表重启丢失实例时下一次续约会重新注册
+			client.Register(ctx, service, target)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return a
+}
+
+func (a *Agent) Stop() error {
+	a.cancel()
+	<-a.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.client.Deregister(ctx, a.service, a.target)
+}
+
+type resolver struct {
+	client  *Client
+	service string
+	wait    time.Duration
+}
+
+// 通过阻塞查询watch注册表中service的实例, 可用于MapPool.SetResolver
+func NewResolver(client *Client, service string) thriftPool.Resolver {
+	return &resolver{client: client, service: service, wait: 30 * time.Second}
+}
+
+func (r *resolver) Watch(ctx context.Context, update func([]thriftPool.Target)) error {
+	var index uint64
+	var wait time.Duration
+	for {
+		s, err := r.client.Service(ctx, r.service, index, wait)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			//注册表不可用时保留当前地址, 稍后重试
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if wait == 0 || s.Index != index {
+			index = s.Index
+			update(s.Instances)
+		}
+		wait = r.wait
+	}
+}
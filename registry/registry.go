@@ -0,0 +1,209 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xkeyideal/ThriftClientPool/thriftPool"
+)
+
+const (
+	DEFAULTTTL   = 15 * time.Second //实例未续约的过期时间
+	MAXWATCHWAIT = 60 * time.Second //watch请求最长阻塞时间
+)
+
+type instance struct {
+	target   thriftPool.Target
+	deadline time.Time
+}
+
+// 服务的全量实例, Index在实例集合每次变化时递增
+type Service struct {
+	Index     uint64              `json:"index"`
+	Instances []thriftPool.Target `json:"instances"`
+}
+
+// 基于TTL续约的内存服务注册表
+type Registry struct {
+	lock     *sync.Mutex
+	ttl      time.Duration
+	index    uint64
+	services map[string]map[string]*instance
+	notify   chan struct{}
+	done     chan struct{}
+}
+
+func NewRegistry(ttl time.Duration) *Registry {
+	if ttl <= 0 {
+		ttl = DEFAULTTTL
+	}
+	r := &Registry{
+		lock:     new(sync.Mutex),
+		ttl:      ttl,
+		services: make(map[string]map[string]*instance),
+		notify:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.expire()
+	return r
+}
+
+func (r *Registry) Close() {
+	close(r.done)
+}
+
+// 以下方法需持有r.lock
+func (r *Registry) changed() {
+	r.index += 1
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+func (r *Registry) service(name string) Service {
+	s := Service{Index: r.index, Instances: []thriftPool.Target{}}
+	for _, inst := range r.services[name] {
+		s.Instances = append(s.Instances, inst.target)
+	}
+	sort.Slice(s.Instances, func(i, j int) bool { return s.Instances[i].Addr() < s.Instances[j].Addr() })
+	return s
+}
+
+// 注册或续约实例
+func (r *Registry) Register(name string, target thriftPool.Target) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	instances, ok := r.services[name]
+	if !ok {
+		instances = make(map[string]*instance)
+		r.services[name] = instances
+	}
+	deadline := time.Now().Add(r.ttl)
+	if inst, ok := instances[target.Addr()]; ok && inst.target == target {
+		inst.deadline = deadline
+		return
+	}
+	instances[target.Addr()] = &instance{target: target, deadline: deadline}
+	r.changed()
+}
+
+func (r *Registry) Deregister(name, addr string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.services[name][addr]; ok {
+		delete(r.services[name], addr)
+		r.changed()
+	}
+}
+
+func (r *Registry) Service(name string) Service {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.service(name)
+}
+
+// 阻塞直到注册表的index大于index、超时或ctx结束, 返回服务的全量实例
+func (r *Registry) Watch(ctx context.Context, name string, index uint64, wait time.Duration) Service {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		r.lock.Lock()
+		if r.index > index {
+			s := r.service(name)
+			r.lock.Unlock()
+			return s
+		}
+		notify := r.notify
+		r.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return r.Service(name)
+		case <-ctx.Done():
+			return r.Service(name)
+		}
+	}
+}
+
+func (r *Registry) expire() {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		r.lock.Lock()
+		expired := false
+		for _, instances := range r.services {
+			for addr, inst := range instances {
+				if now.After(inst.deadline) {
+					delete(instances, addr)
+					expired = true
+				}
+			}
+		}
+		if expired {
+			r.changed()
+		}
+		r.lock.Unlock()
+	}
+}
+
+// HTTP/JSON接口:
+//
+//	PUT    /services/:service/instances  注册或续约, body为thriftPool.Target
+//	DELETE /services/:service/instances/:addr  注销
+//	GET    /services/:service?index=N&wait=30s  带index时阻塞直到注册表index大于N或超时
+func (r *Registry) Handler() http.Handler {
+	router := gin.New()
+
+	router.PUT("/services/:service/instances", func(c *gin.Context) {
+		var target thriftPool.Target
+		if err := c.BindJSON(&target); err != nil {
+			return
+		}
+		if target.Host == "" || target.Port == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "host与port不能为空"})
+			return
+		}
+		r.Register(c.Param("service"), target)
+		c.Status(http.StatusNoContent)
+	})
+
+	router.DELETE("/services/:service/instances/:addr", func(c *gin.Context) {
+		r.Deregister(c.Param("service"), c.Param("addr"))
+		c.Status(http.StatusNoContent)
+	})
+
+	router.GET("/services/:service", func(c *gin.Context) {
+		if c.Query("index") == "" {
+			c.JSON(http.StatusOK, r.Service(c.Param("service")))
+			return
+		}
+		index, _ := strconv.ParseUint(c.Query("index"), 10, 64)
+		wait, err := time.ParseDuration(c.DefaultQuery("wait", "30s"))
+		if err != nil || wait > MAXWATCHWAIT {
+			wait = MAXWATCHWAIT
+		}
+		c.JSON(http.StatusOK, r.Watch(c.Request.Context(), c.Param("service"), index, wait))
+	})
+
+	return router
+}
+
+func RunServer(addr string, ttl time.Duration) error {
+	return http.ListenAndServe(addr, NewRegistry(ttl).Handler())
+}
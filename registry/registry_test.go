@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/gin-gonic/gin"
+	"github.com/xkeyideal/ThriftClientPool/thriftPool"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func pipeDial(ip, port string, connTimeout time.Duration) (*thriftPool.IdleClient, error) {
+	conn, _ := net.Pipe()
+	return &thriftPool.IdleClient{
+		Socket: thrift.NewTSocketFromConnTimeout(conn, connTimeout),
+		Client: struct{}{},
+	}, nil
+}
+
+func pipeClose(c *thriftPool.IdleClient) error {
+	return c.Socket.Close()
+}
+
+func waitEndpoints(t *testing.T, mp *thriftPool.MapPool, want ...string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var got []string
+		for _, p := range mp.Endpoints() {
+			got = append(got, p.Stats().Addr)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") == strings.Join(want, ",") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints:%v, want:%v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistryResolver(t *testing.T) {
+	reg := NewRegistry(300 * time.Millisecond)
+	defer reg.Close()
+	ts := httptest.NewServer(reg.Handler())
+	defer ts.Close()
+	client := NewClient(ts.URL)
+
+	mp := thriftPool.NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetPrewarm(0)
+	mp.SetResolver(NewResolver(client, "rpc"))
+
+	a := StartAgent(client, "rpc", thriftPool.Target{Host: "127.0.0.1", Port: "9000", Zone: "bj"}, 50*time.Millisecond)
+	b := StartAgent(client, "rpc", thriftPool.Target{Host: "127.0.0.1", Port: "9001"}, 50*time.Millisecond)
+	waitEndpoints(t, mp, "127.0.0.1:9000", "127.0.0.1:9001")
+	if z := mp.Stats()["127.0.0.1:9000"].Zone; z != "bj" {
+		t.Fatalf("zone:%s is err", z)
+	}
+
+	//续约期间不过期, 注销后立即移除
+	time.Sleep(500 * time.Millisecond)
+	waitEndpoints(t, mp, "127.0.0.1:9000", "127.0.0.1:9001")
+	if err := a.Stop(); err != nil {
+		t.Fatalf("deregister err:%v", err)
+	}
+	waitEndpoints(t, mp, "127.0.0.1:9001")
+
+	//停止续约后TTL过期
+	ctx := context.Background()
+	if err := client.Register(ctx, "rpc", thriftPool.Target{Host: "127.0.0.1", Port: "9002"}); err != nil {
+		t.Fatalf("register err:%v", err)
+	}
+	waitEndpoints(t, mp, "127.0.0.1:9001", "127.0.0.1:9002")
+	waitEndpoints(t, mp, "127.0.0.1:9001")
+
+	b.Stop()
+	waitEndpoints(t, mp)
+}
+
+func TestRegisterValidation(t *testing.T) {
+	reg := NewRegistry(time.Second)
+	defer reg.Close()
+	ts := httptest.NewServer(reg.Handler())
+	defer ts.Close()
+
+	err := NewClient(ts.URL).Register(context.Background(), "rpc", thriftPool.Target{Host: "127.0.0.1"})
+	if err == nil {
+		t.Fatalf("expect error for empty port")
+	}
+}
@@ -3,10 +3,14 @@ package server
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
+	"sync"
 	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/xkeyideal/ThriftClientPool/registry"
+	"github.com/xkeyideal/ThriftClientPool/thriftPool"
 	"github.com/xkeyideal/ThriftClientPool/tutorial"
 )
 
@@ -14,6 +18,20 @@ const (
 	NetworkAddr = "0.0.0.0:23455"
 )
 
+// RegistryAddr非空时RunServer启动后向注册表注册并续约, StopServer时注销
+var (
+	RegistryAddr  = ""
+	ServiceName   = "rpc"
+	AdvertiseHost = "" //为空时使用本机第一个非回环IPv4地址
+	ServiceZone   = ""
+)
+
+var (
+	serverLock  sync.Mutex
+	rpcServer   *thrift.TSimpleServer
+	serverAgent *registry.Agent
+)
+
 func randInt(limit int32) []int32 {
 	rand.Seed(time.Now().UnixNano())
 	x := []int32{}
@@ -93,13 +111,59 @@ func RunServer() {
 
 	server := thrift.NewTSimpleServer4(processor, serverTransport, transportFactory, protocolFactory)
 	fmt.Println("thrift server in", NetworkAddr)
-	err = server.Serve()
+	if err = server.Listen(); err != nil {
+		fmt.Println("Server Listen Error: ", err)
+		os.Exit(1)
+	}
+
+	serverLock.Lock()
+	rpcServer = server
+	if RegistryAddr != "" {
+		serverAgent = registry.StartAgent(registry.NewClient(RegistryAddr), ServiceName, advertiseTarget(), 0)
+		fmt.Println("thrift server registered to", RegistryAddr)
+	}
+	serverLock.Unlock()
+
+	err = server.AcceptLoop()
 	if err != nil {
 		fmt.Println("Server Run Error: ", err)
 		os.Exit(1)
 	}
 }
 
+// 从注册表注销并停止接受新连接
+func StopServer() {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+
+	if serverAgent != nil {
+		if err := serverAgent.Stop(); err != nil {
+			fmt.Println("Server Deregister Error: ", err)
+		}
+		serverAgent = nil
+	}
+	if rpcServer != nil {
+		rpcServer.Stop()
+		rpcServer = nil
+	}
+}
+
+func advertiseTarget() thriftPool.Target {
+	_, port, _ := net.SplitHostPort(NetworkAddr)
+	host := AdvertiseHost
+	if host == "" {
+		host = "127.0.0.1"
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				host = ipnet.IP.String()
+				break
+			}
+		}
+	}
+	return thriftPool.Target{Host: host, Port: port, Zone: ServiceZone}
+}
+
 func currentTimeMillis() int64 {
 	return time.Now().UnixNano()
 }
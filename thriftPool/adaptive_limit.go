@@ -0,0 +1,158 @@
+package thriftPool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// 基于RPC延迟梯度(gradient)动态调整的并发上限, 上限不超过MaxLimit与池的maxConn
+type AdaptiveLimit struct {
+	InitLimit  uint32
+	MinLimit   uint32
+	MaxLimit   uint32  //0表示使用池的maxConn
+	Smoothing  float64 //新limit的平滑系数, 0~1
+	Tolerance  float64 //短期延迟相对长期基线的容忍倍数, >=1
+	LongWindow int     //长期延迟基线的EWMA窗口(样本数)
+}
+
+var DefaultAdaptiveLimit = AdaptiveLimit{
+	InitLimit:  20,
+	MinLimit:   1,
+	Smoothing:  0.2,
+	Tolerance:  1.5,
+	LongWindow: 600,
+}
+
+var ErrOverload = errors.New("并发请求超过自适应限制")
+
+type OverloadError struct {
+	Addr     string
+	Limit    uint32
+	InFlight uint32
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("Addr:%s %s, limit:%d, inflight:%d", e.Addr, ErrOverload, e.Limit, e.InFlight)
+}
+
+func (e *OverloadError) Is(target error) bool {
+	return target == ErrOverload
+}
+
+type AdaptiveLimiter struct {
+	lock     sync.Mutex
+	cfg      AdaptiveLimit
+	limit    float64
+	inFlight uint32
+	longRTT  float64
+	samples  int
+}
+
+func NewAdaptiveLimiter(cfg AdaptiveLimit) *AdaptiveLimiter {
+	if cfg.MinLimit == 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.InitLimit < cfg.MinLimit {
+		cfg.InitLimit = cfg.MinLimit
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = DefaultAdaptiveLimit.Smoothing
+	}
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = DefaultAdaptiveLimit.Tolerance
+	}
+	if cfg.LongWindow <= 0 {
+		cfg.LongWindow = DefaultAdaptiveLimit.LongWindow
+	}
+	return &AdaptiveLimiter{
+		cfg:   cfg,
+		limit: float64(cfg.InitLimit),
+	}
+}
+
+func (l *AdaptiveLimiter) maxLimit(maxConn uint32) float64 {
+	if l.cfg.MaxLimit > 0 && l.cfg.MaxLimit < maxConn {
+		return float64(l.cfg.MaxLimit)
+	}
+	return float64(maxConn)
+}
+
+// 返回当前限制值, 不超过maxConn
+func (l *AdaptiveLimiter) Limit(maxConn uint32) uint32 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return uint32(math.Min(l.limit, l.maxLimit(maxConn)))
+}
+
+func (l *AdaptiveLimiter) InFlight() uint32 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inFlight
+}
+
+func (l *AdaptiveLimiter) acquire(maxConn uint32) (uint32, uint32, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limit := uint32(math.Min(l.limit, l.maxLimit(maxConn)))
+	if l.inFlight >= limit {
+		return limit, l.inFlight, false
+	}
+	l.inFlight += 1
+	return limit, l.inFlight, true
+}
+
+// 借出失败, 不计入延迟样本
+func (l *AdaptiveLimiter) cancel() {
+	l.lock.Lock()
+	if l.inFlight > 0 {
+		l.inFlight -= 1
+	}
+	l.lock.Unlock()
+}
+
+func (l *AdaptiveLimiter) release(rtt time.Duration, failed bool, maxConn uint32) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	inFlight := l.inFlight
+	if l.inFlight > 0 {
+		l.inFlight -= 1
+	}
+
+	maxLimit := l.maxLimit(maxConn)
+	if failed {
+		l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(l.limit*0.9, maxLimit))
+		return
+	}
+
+	sample := float64(rtt)
+	if sample <= 0 {
+		return
+	}
+	if l.samples < l.cfg.LongWindow {
+		l.samples += 1
+	}
+	if l.longRTT == 0 {
+		l.longRTT = sample
+	} else {
+		l.longRTT += (sample - l.longRTT) / float64(l.samples)
+	}
+	//延迟长期偏离时加速基线衰减, 使其跟上后端的新常态
+	if l.longRTT/sample > 2 {
+		l.longRTT *= 0.95
+	}
+
+	//并发远低于限制时样本不能反映容量, 不调整
+	if float64(inFlight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.cfg.Tolerance*l.longRTT/sample))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-l.cfg.Smoothing) + newLimit*l.cfg.Smoothing
+	l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(newLimit, maxLimit))
+}
@@ -0,0 +1,95 @@
+package thriftPool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 管理接口:
+//
+//	GET  /stats                                 各地址池的统计
+//	POST /drain?endpoint=10.5.20.3:23455&wait=30s 摘除流量, 带wait时等待连接全部关闭或超时
+//	POST /undrain?endpoint=10.5.20.3:23455        恢复流量
+func (mp *MapPool) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, mp.Stats())
+	})
+
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "请使用POST"})
+			return
+		}
+		e, err := parseEndpointParam(r.URL.Query().Get("endpoint"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		drained, err := mp.Drain(e)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+
+		done := false
+		if wait, err := time.ParseDuration(r.URL.Query().Get("wait")); err == nil && wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-drained:
+			case <-timer.C:
+			case <-r.Context().Done():
+			}
+		}
+		select {
+		case <-drained:
+			//等待期间被undrain时channel同样关闭
+			done, _ = mp.endpointDraining(e)
+		default:
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"endpoint": e.String(), "drained": done})
+	})
+
+	mux.HandleFunc("/undrain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "请使用POST"})
+			return
+		}
+		e, err := parseEndpointParam(r.URL.Query().Get("endpoint"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := mp.Undrain(e); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"endpoint": e.String()})
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var errNoEndpointParam = errors.New("缺少endpoint参数")
+
+func parseEndpointParam(s string) (Endpoint, error) {
+	if s == "" {
+		return Endpoint{}, errNoEndpointParam
+	}
+	e, err := ParseEndpoint(s)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("endpoint参数错误: %v", err)
+	}
+	return e, nil
+}
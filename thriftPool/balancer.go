@@ -0,0 +1,129 @@
+package thriftPool
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoEndpoint = errors.New("没有可用的服务地址")
+
+// 从MapPool注册的地址池中为一次借用选择目标, pools非空且调用期间不会被修改
+type Balancer interface {
+	Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error)
+}
+
+// Balancer可选实现, 每次借用结束(Put/CloseErrConn)时获得该地址的延迟与结果
+type Observer interface {
+	Observe(p *ThriftPool, latency time.Duration, failed bool)
+}
+
+type roundRobinBalancer struct {
+	lock    *sync.Mutex
+	current map[*ThriftPool]float64
+}
+
+// 按有效权重平滑加权轮询, 权重相同时即普通轮询
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{
+		lock:    new(sync.Mutex),
+		current: make(map[*ThriftPool]float64),
+	}
+}
+
+func (b *roundRobinBalancer) Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error) {
+	if len(pools) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	weights := effectiveWeights(pools)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.current) > 2*len(pools) {
+		current := make(map[*ThriftPool]float64, len(pools))
+		for _, p := range pools {
+			current[p] = b.current[p]
+		}
+		b.current = current
+	}
+
+	best, total := -1, 0.0
+	for i, p := range pools {
+		b.current[p] += weights[i]
+		total += weights[i]
+		if best < 0 || b.current[p] > b.current[pools[best]] {
+			best = i
+		}
+	}
+	b.current[pools[best]] -= total
+	return pools[best], nil
+}
+
+type randomBalancer struct {
+	lock *sync.Mutex
+	rand *rand.Rand
+}
+
+func NewRandomBalancer() Balancer {
+	return &randomBalancer{
+		lock: new(sync.Mutex),
+		rand: rand.New(rand.NewSource(nowFunc().UnixNano())),
+	}
+}
+
+func (b *randomBalancer) Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error) {
+	if len(pools) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	weights := effectiveWeights(pools)
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+
+	b.lock.Lock()
+	r := b.rand.Float64() * total
+	b.lock.Unlock()
+
+	for i, w := range weights {
+		if r < w {
+			return pools[i], nil
+		}
+		r -= w
+	}
+	return pools[len(pools)-1], nil
+}
+
+type leastInUseBalancer struct {
+	next uint32
+}
+
+// 选择 借出连接数/有效权重 最小的地址池, 相同时轮询
+func NewLeastInUseBalancer() Balancer {
+	return &leastInUseBalancer{}
+}
+
+func (b *leastInUseBalancer) Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error) {
+	if len(pools) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	start := int(atomic.AddUint32(&b.next, 1) % uint32(len(pools)))
+	weights := effectiveWeights(pools)
+
+	best, min := -1, 0.0
+	for i := range pools {
+		j := (start + i) % len(pools)
+		if weights[j] <= 0 {
+			continue
+		}
+		load := float64(pools[j].GetInUseCount()+1) / weights[j]
+		if best < 0 || load < min {
+			best, min = j, load
+		}
+	}
+	return pools[best], nil
+}
@@ -0,0 +1,662 @@
+package thriftPool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestMapPool(n int) *MapPool {
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	for i := 0; i < n; i++ {
+		mp.AddEndpoint("127.0.0.1", fmt.Sprintf("%d", 9000+i))
+	}
+	return mp
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	mp := newTestMapPool(3)
+	defer mp.ReleaseAll()
+
+	ctx := context.Background()
+	picked := make(map[*ThriftPool]int)
+	for i := 0; i < 30; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		picked[c.Pool()] += 1
+		if err := mp.PutClient(c); err != nil {
+			t.Fatalf("put client err:%v", err)
+		}
+	}
+	for _, p := range mp.Endpoints() {
+		if picked[p] != 10 {
+			t.Fatalf("addr:%s picked %d times", p.Stats().Addr, picked[p])
+		}
+		if n := p.GetIdleCount(); n != 1 {
+			t.Fatalf("addr:%s idle count:%d is err", p.Stats().Addr, n)
+		}
+	}
+}
+
+func TestLeastInUseBalancer(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+	mp.SetBalancer(NewLeastInUseBalancer())
+
+	ctx := context.Background()
+	busy := mp.Endpoints()[0]
+	for i := 0; i < 3; i++ {
+		if _, err := busy.Get(); err != nil {
+			t.Fatalf("get conn from pool err:%v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		if c.Pool() == busy {
+			t.Fatalf("picked busy endpoint")
+		}
+	}
+
+	if err := mp.RemoveEndpoint("127.0.0.1", "9001"); err != nil {
+		t.Fatalf("remove endpoint err:%v", err)
+	}
+	c, err := mp.GetClient(ctx)
+	if err != nil || c.Pool() != busy {
+		t.Fatalf("expect busy endpoint, got:%v", err)
+	}
+}
+
+func TestP2CBalancer(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+	balancer := NewP2CBalancer(time.Hour, time.Second)
+	mp.SetBalancer(balancer)
+
+	ctx := context.Background()
+	fast, slow := mp.Endpoints()[0], mp.Endpoints()[1]
+	balancer.(Observer).Observe(fast, time.Millisecond, false)
+	balancer.(Observer).Observe(slow, 100*time.Millisecond, false)
+
+	for i := 0; i < 10; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		if c.Pool() != fast {
+			t.Fatalf("picked slow endpoint")
+		}
+		mp.PutClient(c)
+	}
+
+	//新地址乐观地获得流量, 失败后被惩罚
+	fresh := mp.AddEndpoint("127.0.0.1", "9100")
+	mp.Release("127.0.0.1", "9000")
+	c, err := mp.GetClient(ctx)
+	if err != nil || c.Pool() != fresh {
+		t.Fatalf("expect fresh endpoint, got:%v", err)
+	}
+	mp.CloseErrClient(c)
+	c, err = mp.GetClient(ctx)
+	if err != nil || c.Pool() != slow {
+		t.Fatalf("expect slow endpoint, got:%v", err)
+	}
+}
+
+func TestRingHashBalancer(t *testing.T) {
+	mp := newTestMapPool(4)
+	defer mp.ReleaseAll()
+	mp.SetBalancer(NewRingHashBalancer(0, 0))
+
+	route := func() map[string]*ThriftPool {
+		routes := make(map[string]*ThriftPool)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			c, err := mp.GetClient(WithHashKey(context.Background(), key))
+			if err != nil {
+				t.Fatalf("get client err:%v", err)
+			}
+			routes[key] = c.Pool()
+			mp.PutClient(c)
+		}
+		return routes
+	}
+
+	before := route()
+	again := route()
+	for key, p := range before {
+		if again[key] != p {
+			t.Fatalf("key:%s moved without membership change", key)
+		}
+	}
+
+	mp.AddEndpoint("127.0.0.1", "9100")
+	after := route()
+	moved := 0
+	for key, p := range before {
+		if after[key] != p {
+			moved += 1
+			if after[key].Stats().Addr != "127.0.0.1:9100" {
+				t.Fatalf("key:%s moved between old endpoints", key)
+			}
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Fatalf("moved keys:%d is err", moved)
+	}
+}
+
+func TestRingHashBoundedLoad(t *testing.T) {
+	mp := newTestMapPool(4)
+	defer mp.ReleaseAll()
+	mp.SetBalancer(NewRingHashBalancer(0, 1.25))
+
+	ctx := WithHashKey(context.Background(), "hot-user")
+	picked := make(map[*ThriftPool]int)
+	for i := 0; i < 40; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		picked[c.Pool()] += 1
+	}
+	for p, n := range picked {
+		if n > 13 {
+			t.Fatalf("addr:%s borrowed %d times", p.Stats().Addr, n)
+		}
+	}
+}
+
+func TestWeightedSlowStart(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+
+	ctx := context.Background()
+	big, small := mp.Endpoints()[0], mp.Endpoints()[1]
+	big.SetWeight(3)
+
+	picked := make(map[*ThriftPool]int)
+	for i := 0; i < 40; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		picked[c.Pool()] += 1
+		mp.PutClient(c)
+	}
+	if picked[big] != 30 || picked[small] != 10 {
+		t.Fatalf("picked big:%d small:%d", picked[big], picked[small])
+	}
+
+	mp.SetSlowStart(time.Hour, 0.1)
+	fresh := mp.AddEndpoint("127.0.0.1", "9100")
+	fresh.SetWeight(10)
+	if s := mp.Stats()["127.0.0.1:9100"]; s.Weight != 10 || s.EffectiveWeight < 0.99 || s.EffectiveWeight > 1.01 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+	fresh.lock.Lock()
+	half := fresh.effectiveWeight(fresh.rampStart.Add(30 * time.Minute))
+	fresh.lock.Unlock()
+	if half < 5.49 || half > 5.51 {
+		t.Fatalf("effective weight at half window:%v is err", half)
+	}
+
+	mp.SetSlowStart(0, 0)
+	if s := mp.Stats()["127.0.0.1:9100"]; s.EffectiveWeight != 10 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+}
+
+func TestLocalitySpillover(t *testing.T) {
+	mp := NewMapPool(100, 1, 600, func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+		if port == "9000" {
+			return nil, errors.New("connection refused")
+		}
+		return pipeDial(ip, port, connTimeout)
+	}, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetDialBackoff(DialBackoff{Threshold: 1, Base: time.Hour, Max: time.Hour})
+	mp.SetLocality("bj", 0.7)
+
+	mp.AddEndpoint("127.0.0.1", "9001").SetZone("bj")
+	mp.AddEndpoint("127.0.0.1", "9002").SetZone("sh")
+	mp.AddEndpoint("127.0.0.1", "9003").SetZone("sh")
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		if c.Pool().Zone() != "bj" {
+			t.Fatalf("picked remote zone:%s", c.Pool().Zone())
+		}
+		mp.PutClient(c)
+	}
+
+	//本机房一半地址不可用, 健康比例0.5 < 0.7, 约0.5/0.7的请求留在本机房
+	broken := mp.AddEndpoint("127.0.0.1", "9000")
+	broken.SetZone("bj")
+	broken.Get()
+	picked := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		picked[c.Pool().Zone()] += 1
+		mp.PutClient(c)
+	}
+	if picked["bj"] < 600 || picked["bj"] > 830 || picked["sh"] == 0 {
+		t.Fatalf("picked:%v is err", picked)
+	}
+
+	//慢启动中的本机房地址全部健康, 不溢出
+	ramping := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer ramping.ReleaseAll()
+	ramping.SetSlowStart(time.Minute, 0.1)
+	ramping.SetLocality("bj", 0.7)
+	ramping.AddEndpoint("127.0.0.1", "9001").SetZone("bj")
+	ramping.AddEndpoint("127.0.0.1", "9002").SetZone("bj")
+	ramping.AddEndpoint("127.0.0.1", "9003").SetZone("sh")
+	for i := 0; i < 1000; i++ {
+		c, err := ramping.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		if c.Pool().Zone() != "bj" {
+			t.Fatalf("spilled to %s during slow start", c.Pool().Zone())
+		}
+		ramping.PutClient(c)
+	}
+}
+
+func TestPriorityFailover(t *testing.T) {
+	var down int32 = 1
+	mp := NewMapPool(100, 1, 600, func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+		if port == "9000" && atomic.LoadInt32(&down) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return pipeDial(ip, port, connTimeout)
+	}, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetDialBackoff(DialBackoff{Threshold: 1, Base: 50 * time.Millisecond, Max: 50 * time.Millisecond})
+	mp.SetFailback(100 * time.Millisecond)
+
+	events := make(chan [2]int, 10)
+	mp.OnFailover(func(from, to int) {
+		events <- [2]int{from, to}
+	})
+
+	primary := mp.AddEndpoint("127.0.0.1", "9000")
+	mp.AddEndpoint("127.0.0.1", "9001").SetPriority(1)
+
+	ctx := context.Background()
+	if _, err := mp.GetClient(ctx); err == nil {
+		t.Fatalf("expect primary dial err")
+	}
+	c, err := mp.GetClient(ctx)
+	if err != nil || c.Pool() == primary {
+		t.Fatalf("expect backup endpoint, got:%v", err)
+	}
+	mp.PutClient(c)
+	if e := <-events; e != [2]int{0, 1} {
+		t.Fatalf("failover event:%v is err", e)
+	}
+
+	//退避结束后主组恢复, 稳定failback时长后切回
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	c, err = mp.GetClient(ctx)
+	if err != nil || c.Pool() == primary {
+		t.Fatalf("expect backup endpoint before failback, got:%v", err)
+	}
+	mp.PutClient(c)
+
+	time.Sleep(120 * time.Millisecond)
+	c, err = mp.GetClient(ctx)
+	if err != nil || c.Pool() != primary {
+		t.Fatalf("expect primary endpoint after failback, got:%v", err)
+	}
+	mp.PutClient(c)
+	if e := <-events; e != [2]int{1, 0} {
+		t.Fatalf("failback event:%v is err", e)
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	mp := newTestMapPool(4)
+	defer mp.ReleaseAll()
+	mp.SetOutlierDetection(&OutlierDetection{
+		ConsecutiveErrors:  3,
+		LatencyFactor:      3,
+		MinSamples:         1,
+		Interval:           time.Hour,
+		BaseEjection:       time.Hour,
+		MaxEjection:        time.Hour,
+		MaxEjectionPercent: 50,
+	})
+
+	endpoints := mp.Endpoints()
+	fail := func(p *ThriftPool, n int) {
+		for i := 0; i < n; i++ {
+			c, err := p.Get()
+			if err != nil {
+				t.Fatalf("get conn from pool err:%v", err)
+			}
+			p.CloseErrConn(c)
+		}
+	}
+
+	fail(endpoints[0], 2)
+	if !endpoints[0].Healthy() {
+		t.Fatalf("ejected before consecutive errors")
+	}
+	fail(endpoints[0], 1)
+	if endpoints[0].Healthy() {
+		t.Fatalf("expect ejected after consecutive errors")
+	}
+
+	//最多摘除一半地址
+	fail(endpoints[1], 3)
+	fail(endpoints[2], 3)
+	healthy := 0
+	for _, p := range endpoints {
+		if p.Healthy() {
+			healthy += 1
+		}
+	}
+	if healthy != 2 {
+		t.Fatalf("healthy count:%d is err", healthy)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil {
+			t.Fatalf("get client err:%v", err)
+		}
+		if c.Pool() == endpoints[0] || c.Pool() == endpoints[1] {
+			t.Fatalf("picked ejected endpoint")
+		}
+		mp.PutClient(c)
+	}
+}
+
+func TestOutlierEjectionExpiry(t *testing.T) {
+	mp := newTestMapPool(4)
+	defer mp.ReleaseAll()
+	mp.SetSlowStart(time.Hour, 0.1)
+	mp.SetOutlierDetection(&OutlierDetection{
+		ConsecutiveErrors:  3,
+		Interval:           time.Hour,
+		BaseEjection:       50 * time.Millisecond,
+		MaxEjection:        time.Hour,
+		MaxEjectionPercent: 50,
+	})
+
+	p := mp.Endpoints()[0]
+	fail := func() {
+		for i := 0; i < 3; i++ {
+			c, err := p.Get()
+			if err != nil {
+				t.Fatalf("get conn from pool err:%v", err)
+			}
+			p.CloseErrConn(c)
+		}
+	}
+	//注册后的慢启动已经结束
+	p.restartSlowStartAt(nowFunc().Add(-time.Hour))
+
+	fail()
+	if p.Healthy() {
+		t.Fatalf("expect ejected after consecutive errors")
+	}
+	//摘除到期后check运行前恢复流量, 从最小权重开始慢启动
+	time.Sleep(60 * time.Millisecond)
+	if !p.Healthy() {
+		t.Fatalf("expect healthy after ejection expired")
+	}
+	if w := p.EffectiveWeight(); w > 0.2 {
+		t.Fatalf("effective weight after ejection:%v is err", w)
+	}
+	//继续出错时可以再次摘除
+	fail()
+	if p.Healthy() {
+		t.Fatalf("expect ejected again before check")
+	}
+}
+
+func TestOutlierLatency(t *testing.T) {
+	mp := newTestMapPool(4)
+	defer mp.ReleaseAll()
+	cfg := DefaultOutlierDetection
+	cfg.MinSamples = 1
+	cfg.Interval = time.Hour
+	mp.SetOutlierDetection(&cfg)
+
+	endpoints := mp.Endpoints()
+	detector := mp.detector
+	for i, p := range endpoints {
+		latency := 10 * time.Millisecond
+		if i == 3 {
+			latency = time.Second
+		}
+		detector.observe(p, latency, false)
+	}
+	detector.check()
+
+	for i, p := range endpoints {
+		if p.Healthy() != (i != 3) {
+			t.Fatalf("addr:%s healthy:%v is err", p.Stats().Addr, p.Healthy())
+		}
+	}
+}
+
+func TestEvictIdle(t *testing.T) {
+	mp := newTestMapPool(1)
+	defer mp.ReleaseAll()
+
+	var lock sync.Mutex
+	var evicted []string
+	mp.OnEvict(func(e Endpoint) {
+		lock.Lock()
+		evicted = append(evicted, e.String())
+		lock.Unlock()
+	})
+
+	idle := mp.Get("127.0.0.1", "9100")
+	c, _ := idle.Get()
+	idle.Put(c)
+	busy := mp.Get("127.0.0.1", "9101")
+	c, _ = busy.Get()
+
+	mp.SetEvictIdle(20 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := mp.getServerPool("127.0.0.1", "9100"); err == nil {
+		t.Fatalf("unused pool not evicted")
+	}
+	if _, err := idle.Get(); err != ErrPoolClosed {
+		t.Fatalf("evicted pool get err:%v", err)
+	}
+	if _, err := mp.getServerPool("127.0.0.1", "9101"); err != nil {
+		t.Fatalf("pool with in-use conn evicted")
+	}
+	if _, err := mp.getServerPool("127.0.0.1", "9000"); err != nil {
+		t.Fatalf("registered endpoint evicted")
+	}
+	lock.Lock()
+	if len(evicted) != 1 || evicted[0] != "127.0.0.1:9100" {
+		t.Fatalf("evicted:%v is err", evicted)
+	}
+	lock.Unlock()
+
+	busy.Put(c)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := mp.getServerPool("127.0.0.1", "9101"); err == nil {
+		t.Fatalf("returned pool not evicted")
+	}
+
+	//刚由Get取到的地址池不会被淘汰
+	mp.SetEvictIdle(time.Nanosecond)
+	mp.SetEvictIdle(0)
+	stale := mp.Get("127.0.0.1", "9102")
+	stale.lock.Lock()
+	stale.lastUsed = nowFunc().Add(-time.Hour)
+	stale.lock.Unlock()
+	mp.Get("127.0.0.1", "9102")
+	mp.evict(time.Minute)
+	if _, err := mp.getServerPool("127.0.0.1", "9102"); err != nil {
+		t.Fatalf("pool evicted right after Get")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+	ctx := context.Background()
+
+	target := mp.Get("127.0.0.1", "9000")
+	inFlight, _ := target.Get()
+	c, _ := target.Get()
+	target.Put(c)
+
+	drained, err := mp.Drain(TCPEndpoint("127.0.0.1", "9000"))
+	if err != nil {
+		t.Fatalf("drain err:%v", err)
+	}
+	if target.GetIdleCount() != 0 || target.GetConnCount() != 1 {
+		t.Fatalf("idle:%d count:%d after drain", target.GetIdleCount(), target.GetConnCount())
+	}
+	for i := 0; i < 10; i++ {
+		c, err := mp.GetClient(ctx)
+		if err != nil || c.Pool() == target {
+			t.Fatalf("borrow from draining endpoint, err:%v", err)
+		}
+		mp.PutClient(c)
+	}
+	if _, err := target.Get(); err != ErrDraining {
+		t.Fatalf("direct get err:%v", err)
+	}
+
+	select {
+	case <-drained:
+		t.Fatalf("drained before in-flight conn returned")
+	default:
+	}
+	target.Put(inFlight)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("drain not completed")
+	}
+
+	mp.Undrain(TCPEndpoint("127.0.0.1", "9000"))
+	if c, err := target.Get(); err != nil {
+		t.Fatalf("get after undrain err:%v", err)
+	} else {
+		target.Put(c)
+	}
+
+	//未完成时Undrain, 等待者同样返回
+	inFlight, _ = target.Get()
+	drained = target.Drain()
+	target.Undrain()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("drained channel not closed on undrain")
+	}
+	if target.Draining() {
+		t.Fatalf("still draining after undrain")
+	}
+	target.Put(inFlight)
+	if target.GetIdleCount() != 1 {
+		t.Fatalf("conn returned after undrain should be kept, idle:%d", target.GetIdleCount())
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+	ts := httptest.NewServer(mp.AdminHandler())
+	defer ts.Close()
+
+	post := func(path string) (int, map[string]interface{}) {
+		resp, err := http.Post(ts.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if code, body := post("/drain?endpoint=127.0.0.1:9001&wait=1s"); code != http.StatusOK || body["drained"] != true {
+		t.Fatalf("drain code:%d body:%v", code, body)
+	}
+	if !mp.Stats()["127.0.0.1:9001"].Draining {
+		t.Fatalf("endpoint not draining")
+	}
+	if code, _ := post("/drain?endpoint=127.0.0.1:9999"); code != http.StatusNotFound {
+		t.Fatalf("drain unknown endpoint code:%d", code)
+	}
+	if code, _ := post("/drain"); code != http.StatusBadRequest {
+		t.Fatalf("drain without endpoint code:%d", code)
+	}
+	if code, _ := post("/undrain?endpoint=127.0.0.1:9001"); code != http.StatusOK || mp.Stats()["127.0.0.1:9001"].Draining {
+		t.Fatalf("undrain code:%d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats map[string]PoolStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil || len(stats) != 2 {
+		t.Fatalf("stats:%v err:%v", stats, err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	mp := newTestMapPool(2)
+	defer mp.ReleaseAll()
+
+	old := mp.Get("127.0.0.1", "9000")
+	var clients []*IdleClient
+	for i := 0; i < 8; i++ {
+		c, _ := old.Get()
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		old.Put(c)
+	}
+	mp.AddEndpoint("127.0.0.1", "9002")
+	mp.AddEndpoint("127.0.0.1", "9003")
+	mp.SetSlowStart(0, 0)
+
+	cfg := Rebalance{MaxRetire: 3}
+	//旧地址每轮最多关闭MaxRetire个空闲连接, 直到不超过按权重应占的份额
+	for _, want := range []uint32{3, 3, 1, 0} {
+		if n := mp.rebalance(cfg); n != want {
+			t.Fatalf("retired:%d, want:%d", n, want)
+		}
+	}
+	if n := old.GetConnCount(); n != 1 {
+		t.Fatalf("conn count after rebalance:%d is err", n)
+	}
+}
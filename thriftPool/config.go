@@ -0,0 +1,258 @@
+package thriftPool
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"gopkg.in/go-playground/validator.v8"
+	"gopkg.in/yaml.v2"
+)
+
+// YAML格式的连接池配置:
+//
+//	services:
+//	  user:
+//	    endpoints: ["10.5.20.3:23455", "[fd00::3]:23455", "unix:///var/run/user.sock"]
+//	    max_conn: 100
+//	    conn_timeout: 3
+//	    read_timeout: 30s
+//	    idle_timeout: 600
+//	    transport: framed
+//	    protocol: binary
+//	    balancer: p2c
+type RegistryConfig struct {
+	Services map[string]PoolConfig `yaml:"services" validate:"required,min=1,dive"`
+}
+
+type PoolConfig struct {
+	Endpoints    []string         `yaml:"endpoints" validate:"required,min=1,dive,endpoint"`
+	MaxConn      uint32           `yaml:"max_conn" validate:"gt=0"`
+	MaxTotalConn uint32           `yaml:"max_total_conn"`
+	MaxDialing   uint32           `yaml:"max_dialing"`
+	ConnTimeout  uint32           `yaml:"conn_timeout" validate:"gt=0"`  //秒
+	DialTimeout  time.Duration    `yaml:"dial_timeout" validate:"gte=0"` //如500ms, 未配置时使用conn_timeout, 下同
+	ReadTimeout  time.Duration    `yaml:"read_timeout" validate:"gte=0"`
+	WriteTimeout time.Duration    `yaml:"write_timeout" validate:"gte=0"`
+	IdleTimeout  uint32           `yaml:"idle_timeout" validate:"gt=0"` //秒
+	RateLimit    *RateLimitConfig `yaml:"rate_limit"`
+	Transport    string           `yaml:"transport" validate:"omitempty,eq=framed|eq=buffered"`
+	Protocol     string           `yaml:"protocol" validate:"omitempty,eq=binary|eq=compact|eq=json"`
+	Balancer     string           `yaml:"balancer" validate:"omitempty,eq=round_robin|eq=random|eq=least_in_use|eq=p2c|eq=ring_hash"`
+	TLS          *TLSConfig       `yaml:"tls"`
+}
+
+func (cfg PoolConfig) timeouts() Timeouts {
+	t := connTimeouts(cfg.ConnTimeout)
+	if cfg.DialTimeout > 0 {
+		t.Dial = cfg.DialTimeout
+	}
+	if cfg.ReadTimeout > 0 {
+		t.Read = cfg.ReadTimeout
+	}
+	if cfg.WriteTimeout > 0 {
+		t.Write = cfg.WriteTimeout
+	}
+	return t
+}
+
+type RateLimitConfig struct {
+	Rate   float64 `yaml:"rate" validate:"gt=0"`
+	Burst  int     `yaml:"burst" validate:"gte=0"`
+	Reject bool    `yaml:"reject"` //令牌不足时直接拒绝, 默认等待
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(&validator.Config{TagName: "validate", FieldNameTag: "yaml"})
+	v.RegisterValidation("endpoint", func(v *validator.Validate, topStruct reflect.Value, currentStruct reflect.Value,
+		field reflect.Value, fieldType reflect.Type, fieldKind reflect.Kind, param string) bool {
+		_, err := ParseEndpoint(field.String())
+		return err == nil
+	})
+	return v
+}
+
+// 解析并校验配置, 错误信息包含出错的配置项
+func ParseRegistryConfig(data []byte) (*RegistryConfig, error) {
+	cfg := &RegistryConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析连接池配置失败: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func LoadRegistryConfig(path string) (*RegistryConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseRegistryConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *RegistryConfig) Validate() error {
+	var msgs []string
+	if err := validate.Struct(cfg); err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		for _, fe := range errs {
+			msgs = append(msgs, fmt.Sprintf("%s %s", strings.TrimPrefix(fe.NameNamespace, "RegistryConfig."), fieldErrorMsg(fe)))
+		}
+	}
+	for name, pool := range cfg.Services {
+		if tlsCfg := pool.TLS; tlsCfg != nil && (tlsCfg.CertFile == "") != (tlsCfg.KeyFile == "") {
+			msgs = append(msgs, fmt.Sprintf("services[%s].tls cert_file与key_file需同时配置", name))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("连接池配置错误: %s", strings.Join(msgs, "; "))
+}
+
+func fieldErrorMsg(fe *validator.FieldError) string {
+	switch fe.Tag {
+	case "required":
+		return "不能为空"
+	case "min":
+		return fmt.Sprintf("至少需要%s项", fe.Param)
+	case "gt":
+		return fmt.Sprintf("必须大于%s", fe.Param)
+	case "gte":
+		return fmt.Sprintf("不能小于%s", fe.Param)
+	case "endpoint":
+		return fmt.Sprintf("地址%v格式错误, 应为host:port、[ipv6]:port或unix:///path", fe.Value)
+	}
+	if strings.HasPrefix(fe.Tag, "eq|") {
+		//多个eq取或时错误中不带参数, 从字段的tag中取可选值
+		var values []string
+		if field, ok := reflect.TypeOf(PoolConfig{}).FieldByName(fe.Field); ok {
+			for _, rule := range strings.Split(field.Tag.Get("validate"), "|") {
+				if i := strings.Index(rule, "eq="); i >= 0 {
+					values = append(values, rule[i+3:])
+				}
+			}
+		}
+		return fmt.Sprintf("取值%v无效, 可选值: %s", fe.Value, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("不满足校验规则%s", fe.Tag)
+}
+
+// 由thrift生成代码的NewXxxClientFactory创建client, 如
+//
+//	func(t thrift.TTransport, f thrift.TProtocolFactory) interface{} {
+//		return tutorial.NewRpcServiceClientFactory(t, f)
+//	}
+type ClientFactory func(t thrift.TTransport, f thrift.TProtocolFactory) interface{}
+
+// 按配置的transport、protocol与TLS建立连接
+type configDialer struct {
+	transport string
+	protocol  thrift.TProtocolFactory
+	tls       *tls.Config
+	factory   ClientFactory
+}
+
+func newConfigDialer(cfg PoolConfig, factory ClientFactory) (*configDialer, error) {
+	d := &configDialer{transport: cfg.Transport, factory: factory}
+	switch cfg.Protocol {
+	case "compact":
+		d.protocol = thrift.NewTCompactProtocolFactory()
+	case "json":
+		d.protocol = thrift.NewTJSONProtocolFactory()
+	default:
+		d.protocol = thrift.NewTBinaryProtocolFactoryDefault()
+	}
+
+	if cfg.TLS != nil {
+		tlsCfg := &tls.Config{
+			ServerName:         cfg.TLS.ServerName,
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+		}
+		if cfg.TLS.CAFile != "" {
+			pem, err := ioutil.ReadFile(cfg.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("读取CA证书失败: %v", err)
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("CA证书%s中没有有效的证书", cfg.TLS.CAFile)
+			}
+		}
+		if cfg.TLS.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("读取客户端证书失败: %v", err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		d.tls = tlsCfg
+	}
+	return d, nil
+}
+
+func (d *configDialer) dial(e Endpoint, connTimeout time.Duration) (*IdleClient, error) {
+	conn, err := net.DialTimeout(e.Network, e.Address, connTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.tls != nil {
+		tlsCfg := d.tls
+		if tlsCfg.ServerName == "" && e.Network == "tcp" {
+			tlsCfg = tlsCfg.Clone()
+			tlsCfg.ServerName, _ = e.HostPort()
+		}
+		tlsConn := tls.Client(conn, tlsCfg)
+		if connTimeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(connTimeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	socket := NewTimeoutSocket(conn)
+	var trans thrift.TTransport
+	switch d.transport {
+	case "buffered":
+		trans = thrift.NewTBufferedTransport(socket, 8192)
+	default:
+		trans = thrift.NewTFramedTransport(socket)
+	}
+	c := &IdleClient{Socket: socket}
+	c.Client = d.factory(trans, c.TrackProtocol(d.protocol))
+	return c, nil
+}
+
+func closeIdleClient(c *IdleClient) error {
+	return c.Socket.Close()
+}
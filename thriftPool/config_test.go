@@ -0,0 +1,248 @@
+package thriftPool
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+type testClient struct {
+	trans thrift.TTransport
+	proto thrift.TProtocolFactory
+}
+
+func testFactory(t thrift.TTransport, f thrift.TProtocolFactory) interface{} {
+	return &testClient{trans: t, proto: f}
+}
+
+func TestRegistryConfigValidate(t *testing.T) {
+	_, err := ParseRegistryConfig([]byte(`
+services:
+  user:
+    endpoints: ["127.0.0.1:9000", "::1:9000"]
+    max_conn: 0
+    conn_timeout: 3
+    idle_timeout: 600
+    transport: http
+    tls: {cert_file: client.pem}
+`))
+	if err == nil {
+		t.Fatalf("invalid config passed validation")
+	}
+	for _, want := range []string{"services[user].endpoints[1]", "::1:9000", "services[user].max_conn 必须大于0",
+		"services[user].transport 取值http无效", "cert_file与key_file"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("err:%v should contain %q", err, want)
+		}
+	}
+
+	if _, err := ParseRegistryConfig([]byte("services: {}")); err == nil || !strings.Contains(err.Error(), "services") {
+		t.Fatalf("empty services err:%v", err)
+	}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	dir, err := ioutil.TempDir("", "thriftpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pools.yaml")
+	ioutil.WriteFile(path, []byte(`
+services:
+  user:
+    endpoints: ["`+l.Addr().String()+`"]
+    max_conn: 10
+    conn_timeout: 1
+    idle_timeout: 600
+    protocol: compact
+    balancer: p2c
+    rate_limit: {rate: 100, burst: 10}
+`), 0644)
+
+	if _, err := LoadRegistry(path, nil); err == nil || !strings.Contains(err.Error(), "ClientFactory") {
+		t.Fatalf("load without factory err:%v", err)
+	}
+
+	r, err := LoadRegistry(path, map[string]ClientFactory{"user": testFactory})
+	if err != nil {
+		t.Fatalf("load registry err:%v", err)
+	}
+	defer r.Close()
+
+	if _, err := r.Get("order"); err == nil {
+		t.Fatalf("get unknown service should fail")
+	}
+	mp, err := r.Get("user")
+	if err != nil {
+		t.Fatalf("get service err:%v", err)
+	}
+	c, err := mp.GetClient(context.Background())
+	if err != nil {
+		t.Fatalf("get client err:%v", err)
+	}
+	client := c.Client.(*testClient)
+	if _, ok := client.trans.(*thrift.TFramedTransport); !ok {
+		t.Fatalf("transport:%T is err", client.trans)
+	}
+	if _, ok := client.proto.(*trackedProtocolFactory).factory.(*thrift.TCompactProtocolFactory); !ok {
+		t.Fatalf("protocol:%T is err", client.proto)
+	}
+	mp.PutClient(c)
+
+	s := mp.Stats()[l.Addr().String()]
+	if s.MaxConn != 10 || s.Rate != 100 || s.ConnCount == 0 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go io.Copy(ioutil.Discard, conn)
+			}
+		}()
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	dir, err := ioutil.TempDir("", "thriftpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pools.yaml")
+	write := func(content string) {
+		tmp := path + ".tmp"
+		ioutil.WriteFile(tmp, []byte(content), 0644)
+		os.Rename(tmp, path)
+	}
+	write(`
+services:
+  user:
+    endpoints: ["` + addrs[0] + `"]
+    max_conn: 10
+    conn_timeout: 1
+    idle_timeout: 600
+`)
+
+	factories := map[string]ClientFactory{"user": testFactory, "order": testFactory}
+	r, err := LoadRegistry(path, factories)
+	if err != nil {
+		t.Fatalf("load registry err:%v", err)
+	}
+	defer r.Close()
+	reloaded := make(chan error, 10)
+	r.OnReload(func(err error) { reloaded <- err })
+	r.WatchConfig(path, 10*time.Millisecond)
+
+	user, _ := r.Get("user")
+	inFlight, err := user.GetClient(context.Background())
+	if err != nil {
+		t.Fatalf("get client err:%v", err)
+	}
+
+	write(`
+services:
+  user:
+    endpoints: ["` + addrs[1] + `"]
+    max_conn: 20
+    conn_timeout: 1
+    idle_timeout: 600
+    protocol: compact
+  order:
+    endpoints: ["` + addrs[0] + `"]
+    max_conn: 5
+    conn_timeout: 1
+    idle_timeout: 600
+`)
+	if err := <-reloaded; err != nil {
+		t.Fatalf("reload err:%v", err)
+	}
+	if mp, _ := r.Get("user"); mp != user {
+		t.Fatalf("user pool replaced on reload")
+	}
+	if got := endpointAddrs(user); len(got) != 1 || got[0] != addrs[1] {
+		t.Fatalf("user endpoints:%v is err", got)
+	}
+	if s := user.Stats()[addrs[1]]; s.MaxConn != 20 {
+		t.Fatalf("user stats:%+v is err", s)
+	}
+	if r.Services()[0] != "order" {
+		t.Fatalf("services:%v is err", r.Services())
+	}
+
+	//借出中的连接不受影响, 新连接使用新的protocol
+	if err := user.PutClient(inFlight); err != nil {
+		t.Fatalf("put in-flight client err:%v", err)
+	}
+	c, err := user.GetClient(context.Background())
+	if err != nil {
+		t.Fatalf("get client after reload err:%v", err)
+	}
+	if _, ok := c.Client.(*testClient).proto.(*trackedProtocolFactory).factory.(*thrift.TCompactProtocolFactory); !ok {
+		t.Fatalf("protocol after reload:%T is err", c.Client.(*testClient).proto)
+	}
+	user.PutClient(c)
+
+	//无效配置整体拒绝
+	write(`
+services:
+  user:
+    endpoints: ["` + addrs[0] + `"]
+    max_conn: 0
+    conn_timeout: 1
+    idle_timeout: 600
+`)
+	if err := <-reloaded; err == nil {
+		t.Fatalf("invalid config applied")
+	}
+	if s := user.Stats()[addrs[1]]; s.MaxConn != 20 || len(r.Services()) != 2 {
+		t.Fatalf("stats after invalid reload:%+v is err", s)
+	}
+
+	//SIGHUP强制重新加载
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case err := <-reloaded:
+		if err == nil {
+			t.Fatalf("invalid config applied on SIGHUP")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("SIGHUP not handled")
+	}
+}
@@ -0,0 +1,125 @@
+package thriftPool
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTotalOverMax = errors.New("连接超过MapPool设置的总连接数")
+
+// MapPool所有地址池共享的连接数上限, max为0时不限制, 但仍统计总连接数
+type connBudget struct {
+	lock   *sync.Mutex
+	max    uint32
+	count  uint32
+	notify chan struct{}
+	pools  func() []*ThriftPool
+}
+
+func newConnBudget(pools func() []*ThriftPool) *connBudget {
+	return &connBudget{
+		lock:   new(sync.Mutex),
+		notify: make(chan struct{}),
+		pools:  pools,
+	}
+}
+
+// 占用一个连接名额, 已满时返回等待名额释放的通知
+func (b *connBudget) acquire() (bool, chan struct{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.max > 0 && b.count >= b.max {
+		return false, b.notify
+	}
+	b.count += 1
+	return true, nil
+}
+
+func (b *connBudget) release(n uint32) {
+	if n == 0 {
+		return
+	}
+	b.lock.Lock()
+	if b.count < n {
+		n = b.count
+	}
+	b.count -= n
+	b.broadcast()
+	b.lock.Unlock()
+}
+
+// 已满时有连接归还到空闲队列, 唤醒等待者尝试回收
+func (b *connBudget) wake() {
+	b.lock.Lock()
+	if b.max > 0 && b.count >= b.max {
+		b.broadcast()
+	}
+	b.lock.Unlock()
+}
+
+// 需持有b.lock
+func (b *connBudget) broadcast() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *connBudget) over() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.max > 0 && b.count > b.max
+}
+
+// 关闭最久未被借用的地址池中的一个空闲连接, exclude为发起回收的地址池
+func (b *connBudget) reclaim(exclude *ThriftPool) bool {
+	var victim *ThriftPool
+	var lastUsed time.Time
+	for _, p := range b.pools() {
+		if p == exclude {
+			continue
+		}
+		p.lock.Lock()
+		idle, used := p.idle.Len(), p.lastUsed
+		p.lock.Unlock()
+		if idle > 0 && (victim == nil || used.Before(lastUsed)) {
+			victim, lastUsed = p, used
+		}
+	}
+	return victim != nil && victim.reclaimIdle()
+}
+
+// 关闭最早归还的空闲连接
+func (p *ThriftPool) reclaimIdle() bool {
+	p.lock.Lock()
+	ele := p.idle.Front()
+	if ele == nil {
+		p.lock.Unlock()
+		return false
+	}
+	p.idle.Remove(ele)
+	p.decrCount()
+	p.lock.Unlock()
+
+	p.Close(ele.Value.(*idleConn).c)
+	return true
+}
+
+// 所有地址池的连接总数上限, 0表示不限制; 达到上限时先回收最久未使用地址的空闲连接,
+// 无可回收时Get返回ErrTotalOverMax, GetContext等待; 调小时立即回收多余的空闲连接
+func (mp *MapPool) SetMaxTotalConn(maxTotal uint32) {
+	b := mp.budget
+	b.lock.Lock()
+	b.max = maxTotal
+	b.broadcast()
+	b.lock.Unlock()
+
+	for b.over() && b.reclaim(nil) {
+	}
+}
+
+// 所有地址池的连接总数
+func (mp *MapPool) TotalConnCount() uint32 {
+	mp.budget.lock.Lock()
+	defer mp.budget.lock.Unlock()
+	return mp.budget.count
+}
@@ -0,0 +1,83 @@
+package thriftPool
+
+// 需持有p.lock
+func (p *ThriftPool) finishDrain() {
+	select {
+	case <-p.drained:
+	default:
+		close(p.drained)
+	}
+}
+
+// 停止借出新连接并关闭空闲连接, 借出的连接归还时关闭; 返回的channel在全部连接关闭后关闭,
+// 完成前被Undrain时同样关闭, 此时Draining()为false
+func (p *ThriftPool) Drain() <-chan struct{} {
+	p.lock.Lock()
+	if !p.draining {
+		p.draining = true
+		p.drained = make(chan struct{})
+	}
+	drained := p.drained
+
+	var idle []*IdleClient
+	for p.idle.Len() > 0 {
+		ele := p.idle.Front()
+		p.idle.Remove(ele)
+		idle = append(idle, ele.Value.(*idleConn).c)
+		p.decrCount()
+	}
+	if p.count == 0 {
+		p.finishDrain()
+	}
+	p.broadcast()
+	p.lock.Unlock()
+
+	for _, c := range idle {
+		p.Close(c)
+	}
+	return drained
+}
+
+// 恢复借出, 并重新慢启动; 未完成的Drain返回的channel随之关闭
+func (p *ThriftPool) Undrain() {
+	p.lock.Lock()
+	if p.draining {
+		p.draining = false
+		p.rampStart = nowFunc()
+		p.finishDrain()
+	}
+	p.broadcast()
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) Draining() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.draining
+}
+
+// 摘除地址的流量, balancer不再选择该地址; 返回的channel在该地址全部连接关闭或被Undrain后关闭
+func (mp *MapPool) Drain(e Endpoint) (<-chan struct{}, error) {
+	serverPool, err := mp.getEndpointPool(e)
+	if err != nil {
+		return nil, err
+	}
+	return serverPool.Drain(), nil
+}
+
+func (mp *MapPool) endpointDraining(e Endpoint) (bool, error) {
+	serverPool, err := mp.getEndpointPool(e)
+	if err != nil {
+		return false, err
+	}
+	return serverPool.Draining(), nil
+}
+
+func (mp *MapPool) Undrain(e Endpoint) error {
+	serverPool, err := mp.getEndpointPool(e)
+	if err != nil {
+		return err
+	}
+	serverPool.Undrain()
+	return nil
+}
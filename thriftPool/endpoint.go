@@ -0,0 +1,71 @@
+package thriftPool
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// 连接地址, Network为tcp或unix; tcp的Address为host:port, IPv6地址带方括号, unix的Address为socket路径
+type Endpoint struct {
+	Network string
+	Address string
+}
+
+// 以Endpoint建立连接, 设置后优先于ThriftDial使用
+type EndpointDial func(e Endpoint, connTimeout time.Duration) (*IdleClient, error)
+
+func TCPEndpoint(host, port string) Endpoint {
+	return Endpoint{Network: "tcp", Address: net.JoinHostPort(host, port)}
+}
+
+func UnixEndpoint(path string) Endpoint {
+	return Endpoint{Network: "unix", Address: path}
+}
+
+// 解析 host:port、[ipv6]:port 或 unix:///path/to/sock
+func ParseEndpoint(s string) (Endpoint, error) {
+	if strings.HasPrefix(s, "unix:") {
+		path := strings.TrimPrefix(strings.TrimPrefix(s, "unix:"), "//")
+		if path == "" {
+			return Endpoint{}, fmt.Errorf("地址%s格式错误: socket路径为空", s)
+		}
+		return UnixEndpoint(path), nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("地址%s格式错误: %v", s, err)
+	}
+	return TCPEndpoint(host, port), nil
+}
+
+func (e Endpoint) String() string {
+	if e.Network == "unix" {
+		return "unix://" + e.Address
+	}
+	return e.Address
+}
+
+// tcp地址拆分为host与port; unix地址返回socket路径与空port
+func (e Endpoint) HostPort() (string, string) {
+	if e.Network == "unix" {
+		return e.Address, ""
+	}
+	host, port, err := net.SplitHostPort(e.Address)
+	if err != nil {
+		return e.Address, ""
+	}
+	return host, port
+}
+
+// 建立到该地址的thrift socket, 可用于实现EndpointDial
+func (e Endpoint) DialSocket(connTimeout time.Duration) (*thrift.TSocket, error) {
+	conn, err := net.DialTimeout(e.Network, e.Address, connTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewTimeoutSocket(conn), nil
+}
@@ -0,0 +1,124 @@
+package thriftPool
+
+// 单个地址的配置, 零值字段沿用MapPool的默认配置
+type EndpointConfig struct {
+	MaxConn      uint32
+	ConnTimeout  uint32    //秒, 同时作为建立连接与读写超时
+	Timeouts     *Timeouts //非nil时优先于ConnTimeout
+	IdleTimeout  uint32    //秒
+	MaxDialing   uint32
+	RateLimit    *RateLimit
+	Dial         ThriftDial        //只影响之后新建的连接
+	DialEndpoint EndpointDial      //只影响之后新建的连接, 与Dial同时为nil时沿用MapPool的配置
+	Close        ThriftClientClose //仅在地址池创建时生效
+}
+
+func (cfg EndpointConfig) empty() bool {
+	return cfg.MaxConn == 0 && cfg.ConnTimeout == 0 && cfg.Timeouts == nil && cfg.IdleTimeout == 0 && cfg.MaxDialing == 0 &&
+		cfg.RateLimit == nil && cfg.Dial == nil && cfg.DialEndpoint == nil && cfg.Close == nil
+}
+
+// 需持有mp.lock, 返回合并默认配置后的生效配置
+func (mp *MapPool) endpointConfig(addr string) EndpointConfig {
+	cfg := mp.configs[addr]
+	if cfg.MaxConn == 0 {
+		cfg.MaxConn = mp.maxConn
+	}
+	if cfg.Timeouts == nil {
+		var timeouts Timeouts
+		switch {
+		case cfg.ConnTimeout != 0:
+			timeouts = connTimeouts(cfg.ConnTimeout)
+		case mp.timeouts != nil:
+			timeouts = *mp.timeouts
+		default:
+			timeouts = connTimeouts(mp.connTimeout)
+		}
+		cfg.Timeouts = &timeouts
+	}
+	if cfg.ConnTimeout == 0 {
+		cfg.ConnTimeout = mp.connTimeout
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = mp.idleTimeout
+	}
+	if cfg.MaxDialing == 0 {
+		cfg.MaxDialing = mp.maxDialing
+	}
+	if cfg.RateLimit == nil {
+		rateLimit := mp.rateLimit
+		cfg.RateLimit = &rateLimit
+	}
+	if cfg.Dial == nil && cfg.DialEndpoint == nil {
+		cfg.Dial, cfg.DialEndpoint = mp.Dial, mp.DialEndpoint
+	}
+	if cfg.Close == nil {
+		cfg.Close = mp.Close
+	}
+	return cfg
+}
+
+// 需持有mp.lock, 返回每个地址池的生效配置
+func (mp *MapPool) poolConfigs() map[*ThriftPool]EndpointConfig {
+	configs := make(map[*ThriftPool]EndpointConfig, len(mp.pools))
+	for addr, serverPool := range mp.pools {
+		configs[serverPool] = mp.endpointConfig(addr)
+	}
+	return configs
+}
+
+// 设置地址的独立配置, 已存在的地址池立即应用限制与超时; 传入零值恢复默认配置
+func (mp *MapPool) SetEndpointConfig(ip, port string, cfg EndpointConfig) {
+	mp.SetEndpointConfigFor(TCPEndpoint(ip, port), cfg)
+}
+
+// 支持IPv6与unix socket地址的SetEndpointConfig
+func (mp *MapPool) SetEndpointConfigFor(e Endpoint, cfg EndpointConfig) {
+	addr := e.String()
+
+	mp.lock.Lock()
+	old := mp.configs[addr]
+	if cfg.empty() {
+		delete(mp.configs, addr)
+	} else {
+		mp.configs[addr] = cfg
+	}
+	effective := mp.endpointConfig(addr)
+	serverPool, ok := mp.pools[addr]
+	mp.lock.Unlock()
+
+	if !ok {
+		return
+	}
+	serverPool.SetMaxConn(effective.MaxConn)
+	serverPool.SetTimeouts(*effective.Timeouts)
+	serverPool.SetIdleTimeout(effective.IdleTimeout)
+	serverPool.SetMaxDialing(effective.MaxDialing)
+	//Dial只影响之后新建的连接
+	serverPool.SetDial(effective.Dial, effective.DialEndpoint)
+	if old.RateLimit != cfg.RateLimit {
+		serverPool.SetRateLimit(*effective.RateLimit)
+	}
+}
+
+// 按独立配置注册地址, 等同于SetEndpointConfig后AddEndpoint
+func (mp *MapPool) AddEndpointConfig(ip, port string, cfg EndpointConfig) *ThriftPool {
+	return mp.RegisterEndpointConfig(TCPEndpoint(ip, port), cfg)
+}
+
+// 等同于SetEndpointConfigFor后RegisterEndpoint
+func (mp *MapPool) RegisterEndpointConfig(e Endpoint, cfg EndpointConfig) *ThriftPool {
+	mp.SetEndpointConfigFor(e, cfg)
+	return mp.RegisterEndpoint(e)
+}
+
+// 地址当前的生效配置
+func (mp *MapPool) EndpointConfig(ip, port string) EndpointConfig {
+	return mp.EndpointConfigFor(TCPEndpoint(ip, port))
+}
+
+func (mp *MapPool) EndpointConfigFor(e Endpoint) EndpointConfig {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	return mp.endpointConfig(e.String())
+}
@@ -0,0 +1,82 @@
+package thriftPool
+
+import (
+	"time"
+)
+
+// timeout内没有被Get或借用且没有借出连接的地址池自动释放并从MapPool中移除, 0表示关闭;
+// 通过AddEndpoint注册的地址由RemoveEndpoint或resolver管理, 不会被淘汰
+func (mp *MapPool) SetEvictIdle(timeout time.Duration) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if mp.evictStop != nil {
+		close(mp.evictStop)
+		mp.evictStop = nil
+	}
+	if timeout <= 0 {
+		return
+	}
+	mp.evictStop = make(chan struct{})
+	go mp.runEvict(timeout, mp.evictStop)
+}
+
+// 地址池被自动淘汰后回调
+func (mp *MapPool) OnEvict(hook func(e Endpoint)) {
+	mp.lock.Lock()
+	mp.evictHooks = append(mp.evictHooks, hook)
+	mp.lock.Unlock()
+}
+
+func (mp *MapPool) runEvict(timeout time.Duration, stop chan struct{}) {
+	interval := timeout / 2
+	if interval > CHECKINTERVAL*time.Second {
+		interval = CHECKINTERVAL * time.Second
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			mp.evict(timeout)
+		}
+	}
+}
+
+func (mp *MapPool) evict(timeout time.Duration) {
+	now := nowFunc()
+
+	mp.lock.Lock()
+	registered := make(map[*ThriftPool]bool, len(mp.endpoints))
+	for _, p := range mp.endpoints {
+		registered[p] = true
+	}
+	var evicted []*ThriftPool
+	for addr, p := range mp.pools {
+		if registered[p] {
+			continue
+		}
+		p.lock.Lock()
+		unused := p.count == uint32(p.idle.Len()) && now.Sub(p.lastUsed) >= timeout
+		p.lock.Unlock()
+		if unused {
+			delete(mp.pools, addr)
+			evicted = append(evicted, p)
+		}
+	}
+	hooks := mp.evictHooks
+	mp.lock.Unlock()
+
+	for _, p := range evicted {
+		p.Release()
+		for _, hook := range hooks {
+			hook(p.endpoint)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package thriftPool
+
+import (
+	"math/rand"
+)
+
+const (
+	SPILLOVER = 0.7 //本机房健康容量占比低于该值时按比例溢出到其他机房
+)
+
+func (p *ThriftPool) SetZone(zone string) {
+	p.lock.Lock()
+	p.zone = zone
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) Zone() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.zone
+}
+
+// 设置客户端所在机房; 本机房健康地址的权重占本机房总权重的比例不低于threshold时只访问本机房,
+// 低于时按 比例/threshold 的概率访问本机房, 其余溢出到其他机房. zone为空关闭就近路由
+func (mp *MapPool) SetLocality(zone string, threshold float64) {
+	if threshold <= 0 || threshold > 1 {
+		threshold = SPILLOVER
+	}
+	mp.lock.Lock()
+	mp.locality = zone
+	mp.spillover = threshold
+	mp.lock.Unlock()
+}
+
+func (mp *MapPool) localize(endpoints, healthy []*ThriftPool) []*ThriftPool {
+	mp.lock.Lock()
+	locality, threshold := mp.locality, mp.spillover
+	mp.lock.Unlock()
+
+	if locality == "" {
+		return healthy
+	}
+
+	var localTotal, localHealthy float64
+	for _, p := range endpoints {
+		if p.Zone() == locality {
+			localTotal += float64(p.Weight())
+		}
+	}
+	if localTotal == 0 {
+		return healthy
+	}
+
+	local := make([]*ThriftPool, 0, len(healthy))
+	remote := make([]*ThriftPool, 0, len(healthy))
+	for _, p := range healthy {
+		if p.Zone() == locality {
+			local = append(local, p)
+			//与localTotal同样按配置的权重计算, 慢启动中的地址不算作容量不足
+			if p.Healthy() {
+				localHealthy += float64(p.Weight())
+			}
+		} else {
+			remote = append(remote, p)
+		}
+	}
+	if len(local) == 0 {
+		return healthy
+	}
+	if len(remote) == 0 {
+		return local
+	}
+
+	share := localHealthy / localTotal / threshold
+	if share >= 1 || rand.Float64() < share {
+		return local
+	}
+	return remote
+}
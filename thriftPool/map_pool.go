@@ -0,0 +1,478 @@
+package thriftPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type MapPool struct {
+	Dial         ThriftDial
+	Close        ThriftClientClose
+	DialEndpoint EndpointDial //非nil时优先于Dial
+
+	lock *sync.Mutex
+
+	idleTimeout uint32
+	connTimeout uint32
+	timeouts    *Timeouts //nil时按connTimeout
+	maxConn     uint32
+	maxDialing  uint32
+	backoff     DialBackoff
+	rateLimit   RateLimit
+	adaptive    *AdaptiveLimit
+	slowStart   time.Duration
+	slowMin     float64
+	locality    string
+	spillover   float64
+
+	//优先级组故障切换
+	activePriority int
+	betterSince    time.Time
+	failback       time.Duration
+	failoverHooks  []func(from, to int)
+
+	detector *outlierDetector
+
+	resolverCancel context.CancelFunc
+	prewarm        uint32
+	targets        []Target //最近一次UpdateTargets的全量地址
+	updateLock     *sync.Mutex
+
+	//地址子集
+	subsetID   string
+	subsetSize int
+
+	pools map[string]*ThriftPool
+
+	//按地址的独立配置
+	configs map[string]EndpointConfig
+
+	budget *connBudget
+
+	//自动淘汰长期未使用的地址池
+	evictStop  chan struct{}
+	evictHooks []func(e Endpoint)
+
+	rebalanceStop chan struct{}
+
+	//通过AddEndpoint注册的同一服务的地址, 由balancer选择
+	endpoints []*ThriftPool
+	balancer  Balancer
+}
+
+func NewMapPool(maxConn, connTimeout, idleTimeout uint32,
+	dial ThriftDial, closeFunc ThriftClientClose) *MapPool {
+
+	mp := &MapPool{
+		Dial:        dial,
+		Close:       closeFunc,
+		maxConn:     maxConn,
+		idleTimeout: idleTimeout,
+		connTimeout: connTimeout,
+		maxDialing:  MAXDIALING,
+		backoff:     DefaultDialBackoff,
+		pools:       make(map[string]*ThriftPool),
+		configs:     make(map[string]EndpointConfig),
+		balancer:    NewRoundRobinBalancer(),
+		spillover:   SPILLOVER,
+		failback:    FAILBACKDELAY,
+		prewarm:     1,
+		updateLock:  new(sync.Mutex),
+		lock:        new(sync.Mutex),
+
+		activePriority: -1,
+	}
+	mp.budget = newConnBudget(func() []*ThriftPool {
+		mp.lock.Lock()
+		defer mp.lock.Unlock()
+		return mp.serverPools()
+	})
+	return mp
+}
+
+// 需持有mp.lock
+func (mp *MapPool) newServerPool(e Endpoint) *ThriftPool {
+	cfg := mp.endpointConfig(e.String())
+
+	serverPool := NewEndpointPool(e,
+		cfg.MaxConn,
+		cfg.ConnTimeout,
+		cfg.IdleTimeout,
+		cfg.DialEndpoint,
+		cfg.Close,
+	)
+	serverPool.Dial = cfg.Dial
+	serverPool.SetTimeouts(*cfg.Timeouts)
+	serverPool.SetMaxDialing(cfg.MaxDialing)
+	serverPool.SetDialBackoff(mp.backoff)
+	serverPool.SetRateLimit(*cfg.RateLimit)
+	if mp.adaptive != nil {
+		serverPool.SetAdaptiveLimiter(NewAdaptiveLimiter(*mp.adaptive))
+	}
+	serverPool.SetSlowStart(mp.slowStart, mp.slowMin)
+	serverPool.observer = mp.observe
+	serverPool.budget = mp.budget
+	return serverPool
+}
+
+// 地址池每次借用结束时回调
+func (mp *MapPool) observe(serverPool *ThriftPool, latency time.Duration, failed bool) {
+	mp.lock.Lock()
+	balancer, detector := mp.balancer, mp.detector
+	mp.lock.Unlock()
+
+	if o, ok := balancer.(Observer); ok {
+		o.Observe(serverPool, latency, failed)
+	}
+	if detector != nil {
+		detector.observe(serverPool, latency, failed)
+	}
+}
+
+func (mp *MapPool) getServerPool(ip, port string) (*ThriftPool, error) {
+	return mp.getEndpointPool(TCPEndpoint(ip, port))
+}
+
+func (mp *MapPool) getEndpointPool(e Endpoint) (*ThriftPool, error) {
+	addr := e.String()
+	mp.lock.Lock()
+	serverPool, ok := mp.pools[addr]
+	if !ok {
+		mp.lock.Unlock()
+		err := errors.New(fmt.Sprintf("Addr:%s thrift pool not exist", addr))
+		return nil, err
+	}
+	mp.lock.Unlock()
+	return serverPool, nil
+}
+
+func (mp *MapPool) Get(ip, port string) *ThriftPool {
+	return mp.GetEndpoint(TCPEndpoint(ip, port))
+}
+
+// 获取地址池, 不存在时创建; 并发调用只会创建一个
+func (mp *MapPool) GetEndpoint(e Endpoint) *ThriftPool {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	serverPool, ok := mp.pools[e.String()]
+	if !ok {
+		serverPool = mp.newServerPool(e)
+		mp.pools[e.String()] = serverPool
+	}
+	//与evict在同一把锁下更新, 取到的地址池不会随即被淘汰
+	serverPool.lock.Lock()
+	serverPool.lastUsed = nowFunc()
+	serverPool.lock.Unlock()
+	return serverPool
+}
+
+func (mp *MapPool) Release(ip, port string) error {
+	return mp.ReleaseEndpoint(TCPEndpoint(ip, port))
+}
+
+func (mp *MapPool) ReleaseEndpoint(e Endpoint) error {
+	mp.lock.Lock()
+	serverPool, ok := mp.pools[e.String()]
+	if !ok {
+		mp.lock.Unlock()
+		return errors.New(fmt.Sprintf("Addr:%s thrift pool not exist", e))
+	}
+	delete(mp.pools, e.String())
+	mp.removeEndpoint(serverPool)
+	mp.lock.Unlock()
+
+	serverPool.Release()
+
+	return nil
+}
+
+// 将地址加入负载均衡的地址集合, 重复添加无副作用
+func (mp *MapPool) AddEndpoint(ip, port string) *ThriftPool {
+	return mp.RegisterEndpoint(TCPEndpoint(ip, port))
+}
+
+func (mp *MapPool) RegisterEndpoint(e Endpoint) *ThriftPool {
+	serverPool := mp.GetEndpoint(e)
+
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	for _, p := range mp.endpoints {
+		if p == serverPool {
+			return serverPool
+		}
+	}
+	mp.endpoints = append(mp.endpoints, serverPool)
+	serverPool.RestartSlowStart()
+	return serverPool
+}
+
+// 从地址集合中移除并释放该地址的连接池
+func (mp *MapPool) RemoveEndpoint(ip, port string) error {
+	return mp.ReleaseEndpoint(TCPEndpoint(ip, port))
+}
+
+// 需持有mp.lock
+func (mp *MapPool) removeEndpoint(serverPool *ThriftPool) {
+	for i, p := range mp.endpoints {
+		if p == serverPool {
+			endpoints := make([]*ThriftPool, 0, len(mp.endpoints)-1)
+			endpoints = append(endpoints, mp.endpoints[:i]...)
+			mp.endpoints = append(endpoints, mp.endpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+func (mp *MapPool) Endpoints() []*ThriftPool {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	return mp.endpoints
+}
+
+func (mp *MapPool) SetBalancer(balancer Balancer) {
+	mp.lock.Lock()
+	mp.balancer = balancer
+	mp.lock.Unlock()
+}
+
+// 由balancer从已注册的地址中选择一个并借出连接, 用完后调用PutClient或CloseErrClient
+func (mp *MapPool) GetClient(ctx context.Context) (*IdleClient, error) {
+	return mp.GetClientMethod(ctx, "")
+}
+
+func (mp *MapPool) GetClientMethod(ctx context.Context, method string) (*IdleClient, error) {
+	mp.lock.Lock()
+	endpoints, balancer := mp.endpoints, mp.balancer
+	mp.lock.Unlock()
+
+	candidates := mp.candidates(endpoints)
+	if len(candidates) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	serverPool, err := balancer.Pick(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	client, err := serverPool.GetMethod(ctx, method)
+	if err != nil && ctx.Err() == nil {
+		//连接失败、退避等借用失败同样反馈给balancer, 异常检测只统计RPC结果
+		if o, ok := balancer.(Observer); ok {
+			o.Observe(serverPool, 0, true)
+		}
+	}
+	return client, err
+}
+
+// 交给balancer前的地址筛选: 排除摘除流量的地址与不健康的地址(全部不健康时不排除), 选出当前优先级组, 再按机房就近选择
+func (mp *MapPool) candidates(all []*ThriftPool) []*ThriftPool {
+	endpoints := make([]*ThriftPool, 0, len(all))
+	for _, p := range all {
+		if !p.Draining() {
+			endpoints = append(endpoints, p)
+		}
+	}
+	healthy := make([]*ThriftPool, 0, len(endpoints))
+	for _, p := range endpoints {
+		if p.Healthy() {
+			healthy = append(healthy, p)
+		}
+	}
+	if len(healthy) == 0 {
+		return mp.localize(endpoints, endpoints)
+	}
+
+	priority := mp.selectPriority(healthy)
+	group := make([]*ThriftPool, 0, len(endpoints))
+	for _, p := range endpoints {
+		if p.Priority() == priority {
+			group = append(group, p)
+		}
+	}
+	healthyGroup := make([]*ThriftPool, 0, len(healthy))
+	for _, p := range healthy {
+		if p.Priority() == priority {
+			healthyGroup = append(healthyGroup, p)
+		}
+	}
+	return mp.localize(group, healthyGroup)
+}
+
+// 归还到借出该client的地址池
+func (mp *MapPool) PutClient(client *IdleClient) error {
+	if client == nil || client.pool == nil {
+		return ErrInvalidConn
+	}
+	return client.pool.Put(client)
+}
+
+func (mp *MapPool) CloseErrClient(client *IdleClient) {
+	if client == nil || client.pool == nil {
+		return
+	}
+	client.pool.CloseErrConn(client)
+}
+
+// 以下设置对已存在及之后创建的地址池生效, 设置了独立配置的地址保持其独立配置
+func (mp *MapPool) SetMaxConn(maxConn uint32) {
+	mp.lock.Lock()
+	mp.maxConn = maxConn
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetMaxConn(cfg.MaxConn)
+	}
+}
+
+func (mp *MapPool) SetIdleTimeout(idleTimeout uint32) {
+	mp.lock.Lock()
+	mp.idleTimeout = idleTimeout
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetIdleTimeout(cfg.IdleTimeout)
+	}
+}
+
+func (mp *MapPool) SetConnTimeout(connTimeout uint32) {
+	mp.lock.Lock()
+	mp.connTimeout = connTimeout
+	mp.timeouts = nil
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetTimeouts(*cfg.Timeouts)
+	}
+}
+
+func (mp *MapPool) SetMaxDialing(maxDialing uint32) {
+	mp.lock.Lock()
+	mp.maxDialing = maxDialing
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetMaxDialing(cfg.MaxDialing)
+	}
+}
+
+func (mp *MapPool) SetDialBackoff(backoff DialBackoff) {
+	mp.lock.Lock()
+	mp.backoff = backoff
+	pools := mp.serverPools()
+	mp.lock.Unlock()
+
+	for _, serverPool := range pools {
+		serverPool.SetDialBackoff(backoff)
+	}
+}
+
+// 只影响之后新建的连接, 设置了独立Dial的地址保持其独立配置
+func (mp *MapPool) SetDial(dial ThriftDial, dialEndpoint EndpointDial) {
+	mp.lock.Lock()
+	mp.Dial, mp.DialEndpoint = dial, dialEndpoint
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetDial(cfg.Dial, cfg.DialEndpoint)
+	}
+}
+
+// 每个地址池各自持有一份按cfg创建的令牌桶
+func (mp *MapPool) SetRateLimit(cfg RateLimit) {
+	mp.lock.Lock()
+	mp.rateLimit = cfg
+	var pools []*ThriftPool
+	for addr, serverPool := range mp.pools {
+		if mp.configs[addr].RateLimit == nil {
+			pools = append(pools, serverPool)
+		}
+	}
+	mp.lock.Unlock()
+
+	for _, serverPool := range pools {
+		serverPool.SetRateLimit(cfg)
+	}
+}
+
+// 每个地址池各自维护一个自适应并发限制, 传入nil关闭
+func (mp *MapPool) SetAdaptiveLimit(cfg *AdaptiveLimit) {
+	mp.lock.Lock()
+	mp.adaptive = cfg
+	pools := mp.serverPools()
+	mp.lock.Unlock()
+
+	for _, serverPool := range pools {
+		if cfg == nil {
+			serverPool.SetAdaptiveLimiter(nil)
+		} else {
+			serverPool.SetAdaptiveLimiter(NewAdaptiveLimiter(*cfg))
+		}
+	}
+}
+
+func (mp *MapPool) Stats() map[string]PoolStats {
+	mp.lock.Lock()
+	pools := mp.serverPools()
+	mp.lock.Unlock()
+
+	stats := make(map[string]PoolStats, len(pools))
+	for _, serverPool := range pools {
+		s := serverPool.Stats()
+		stats[s.Addr] = s
+	}
+	return stats
+}
+
+// 新注册或恢复的地址在window内权重从minFraction线性增长到完整权重
+func (mp *MapPool) SetSlowStart(window time.Duration, minFraction float64) {
+	mp.lock.Lock()
+	mp.slowStart, mp.slowMin = window, minFraction
+	pools := mp.serverPools()
+	mp.lock.Unlock()
+
+	for _, serverPool := range pools {
+		serverPool.SetSlowStart(window, minFraction)
+	}
+}
+
+// 需持有mp.lock
+func (mp *MapPool) serverPools() []*ThriftPool {
+	pools := make([]*ThriftPool, 0, len(mp.pools))
+	for _, serverPool := range mp.pools {
+		pools = append(pools, serverPool)
+	}
+	return pools
+}
+
+func (mp *MapPool) ReleaseAll() {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if mp.detector != nil {
+		mp.detector.stop()
+		mp.detector = nil
+	}
+	if mp.resolverCancel != nil {
+		mp.resolverCancel()
+		mp.resolverCancel = nil
+	}
+	if mp.evictStop != nil {
+		close(mp.evictStop)
+		mp.evictStop = nil
+	}
+	if mp.rebalanceStop != nil {
+		close(mp.rebalanceStop)
+		mp.rebalanceStop = nil
+	}
+	for _, serverPool := range mp.pools {
+		serverPool.Release()
+	}
+}
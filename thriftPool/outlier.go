@@ -0,0 +1,240 @@
+package thriftPool
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 基于RPC结果的异常检测: 连续错误或区间平均延迟远高于同组地址的中位数时摘除该地址,
+// 摘除时长随被摘除次数指数增长; 被摘除地址占比不超过MaxEjectionPercent
+type OutlierDetection struct {
+	ConsecutiveErrors  uint32        //0表示不按连续错误摘除
+	LatencyFactor      float64       //0表示不按延迟摘除
+	MinSamples         uint32        //区间内样本数不足时不参与延迟比较
+	Interval           time.Duration //延迟分析与恢复检查的间隔
+	BaseEjection       time.Duration
+	MaxEjection        time.Duration
+	MaxEjectionPercent float64 //0~100
+}
+
+var DefaultOutlierDetection = OutlierDetection{
+	ConsecutiveErrors:  5,
+	LatencyFactor:      3,
+	MinSamples:         20,
+	Interval:           10 * time.Second,
+	BaseEjection:       30 * time.Second,
+	MaxEjection:        5 * time.Minute,
+	MaxEjectionPercent: 50,
+}
+
+type outlierStat struct {
+	consecutive uint32
+	ejections   uint32
+	ejected     bool
+	latencySum  time.Duration
+	samples     uint32
+}
+
+type outlierDetector struct {
+	mp    *MapPool
+	cfg   OutlierDetection
+	lock  *sync.Mutex
+	stats map[*ThriftPool]*outlierStat
+	done  chan struct{}
+}
+
+// 传入nil关闭异常检测并恢复所有被摘除的地址
+func (mp *MapPool) SetOutlierDetection(cfg *OutlierDetection) {
+	var detector *outlierDetector
+	if cfg != nil {
+		detector = &outlierDetector{
+			mp:    mp,
+			cfg:   *cfg,
+			lock:  new(sync.Mutex),
+			stats: make(map[*ThriftPool]*outlierStat),
+			done:  make(chan struct{}),
+		}
+		if detector.cfg.Interval <= 0 {
+			detector.cfg.Interval = DefaultOutlierDetection.Interval
+		}
+	}
+
+	mp.lock.Lock()
+	old := mp.detector
+	mp.detector = detector
+	endpoints := mp.endpoints
+	mp.lock.Unlock()
+
+	if old != nil {
+		old.stop()
+		now := nowFunc()
+		for _, p := range endpoints {
+			if now.Before(p.EjectedUntil()) {
+				p.RestartSlowStart()
+			}
+			p.setEjected(time.Time{})
+		}
+	}
+	if detector != nil {
+		go detector.run()
+	}
+}
+
+func (p *ThriftPool) setEjected(until time.Time) {
+	p.lock.Lock()
+	p.ejectedUntil = until
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) EjectedUntil() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.ejectedUntil
+}
+
+func (d *outlierDetector) stop() {
+	close(d.done)
+}
+
+// 需持有d.lock; 摘除到期后check清除标记前同样视为未摘除, 可以再次被摘除
+func (d *outlierDetector) ejected(p *ThriftPool, stat *outlierStat, now time.Time) bool {
+	return stat.ejected && now.Before(p.EjectedUntil())
+}
+
+// 需持有d.lock
+func (d *outlierDetector) stat(p *ThriftPool) *outlierStat {
+	stat, ok := d.stats[p]
+	if !ok {
+		stat = &outlierStat{}
+		d.stats[p] = stat
+	}
+	return stat
+}
+
+func (d *outlierDetector) observe(p *ThriftPool, latency time.Duration, failed bool) {
+	d.lock.Lock()
+	stat := d.stat(p)
+	if !failed {
+		stat.consecutive = 0
+		stat.latencySum += latency
+		stat.samples += 1
+		d.lock.Unlock()
+		return
+	}
+	stat.consecutive += 1
+	eject := d.cfg.ConsecutiveErrors > 0 && stat.consecutive >= d.cfg.ConsecutiveErrors && !d.ejected(p, stat, nowFunc())
+	d.lock.Unlock()
+
+	if eject {
+		d.eject([]*ThriftPool{p})
+	}
+}
+
+func (d *outlierDetector) eject(pools []*ThriftPool) {
+	endpoints := d.mp.Endpoints()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := nowFunc()
+	ejected := 0
+	for _, p := range endpoints {
+		if d.ejected(p, d.stat(p), now) {
+			ejected += 1
+		}
+	}
+
+	for _, p := range pools {
+		stat := d.stat(p)
+		if d.ejected(p, stat, now) {
+			continue
+		}
+		if float64(ejected+1)*100 > d.cfg.MaxEjectionPercent*float64(len(endpoints)) {
+			return
+		}
+
+		stat.ejected = true
+		stat.ejections += 1
+		stat.consecutive = 0
+		ejected += 1
+
+		duration := d.cfg.BaseEjection
+		for i := uint32(1); i < stat.ejections && (d.cfg.MaxEjection <= 0 || duration < d.cfg.MaxEjection); i++ {
+			duration *= 2
+		}
+		if d.cfg.MaxEjection > 0 && duration > d.cfg.MaxEjection {
+			duration = d.cfg.MaxEjection
+		}
+		//摘除到期时恢复流量, 同时开始慢启动
+		p.setEjected(now.Add(duration))
+		p.restartSlowStartAt(now.Add(duration))
+	}
+}
+
+func (d *outlierDetector) run() {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.check()
+		}
+	}
+}
+
+func (d *outlierDetector) check() {
+	endpoints := d.mp.Endpoints()
+	now := nowFunc()
+
+	type sample struct {
+		pool    *ThriftPool
+		latency float64
+	}
+	var samples []sample
+
+	d.lock.Lock()
+	live := make(map[*ThriftPool]*outlierStat, len(endpoints))
+	for _, p := range endpoints {
+		stat := d.stat(p)
+		live[p] = stat
+
+		if stat.ejected {
+			//与eject在同一把锁下清除, 慢启动已在摘除时从到期时间开始
+			if !now.Before(p.EjectedUntil()) {
+				stat.ejected = false
+				p.setEjected(time.Time{})
+			}
+		} else if stat.ejections > 0 && stat.consecutive == 0 {
+			//表现正常的区间逐步降低摘除次数
+			stat.ejections -= 1
+		}
+
+		if !stat.ejected && stat.samples > 0 && stat.samples >= d.cfg.MinSamples {
+			samples = append(samples, sample{p, float64(stat.latencySum) / float64(stat.samples)})
+		}
+		stat.latencySum, stat.samples = 0, 0
+	}
+	d.stats = live
+	d.lock.Unlock()
+
+	if d.cfg.LatencyFactor <= 0 || len(samples) < 3 {
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].latency < samples[j].latency })
+	median := samples[len(samples)/2].latency
+
+	var outliers []*ThriftPool
+	for i := len(samples) - 1; i >= 0; i-- {
+		if samples[i].latency <= d.cfg.LatencyFactor*median {
+			break
+		}
+		outliers = append(outliers, samples[i].pool)
+	}
+	if len(outliers) > 0 {
+		d.eject(outliers)
+	}
+}
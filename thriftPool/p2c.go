@@ -0,0 +1,131 @@
+package thriftPool
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	P2CDECAY   = 10 * time.Second //延迟EWMA的衰减时间常数
+	P2CPENALTY = time.Second      //失败时延迟EWMA至少被提升到的值
+)
+
+type p2cStat struct {
+	ewma float64 //纳秒
+	last time.Time
+}
+
+type p2cBalancer struct {
+	lock    *sync.Mutex
+	rand    *rand.Rand
+	decay   time.Duration
+	penalty time.Duration
+	stats   map[*ThriftPool]*p2cStat
+}
+
+// 随机选两个地址, 取 延迟EWMA*(借出数+1)/有效权重 较小者; decay、penalty为0时使用默认值
+func NewP2CBalancer(decay, penalty time.Duration) Balancer {
+	if decay <= 0 {
+		decay = P2CDECAY
+	}
+	if penalty <= 0 {
+		penalty = P2CPENALTY
+	}
+	return &p2cBalancer{
+		lock:    new(sync.Mutex),
+		rand:    rand.New(rand.NewSource(nowFunc().UnixNano())),
+		decay:   decay,
+		penalty: penalty,
+		stats:   make(map[*ThriftPool]*p2cStat),
+	}
+}
+
+// 需持有b.lock, 按距上次样本的时间衰减
+func (b *p2cBalancer) latency(p *ThriftPool, now time.Time) float64 {
+	stat, ok := b.stats[p]
+	if !ok {
+		//新地址乐观地取当前最小延迟, 使其尽快获得流量
+		stat = &p2cStat{ewma: b.minLatency(), last: now}
+		b.stats[p] = stat
+	}
+	elapsed := now.Sub(stat.last)
+	if elapsed <= 0 {
+		return stat.ewma
+	}
+	return stat.ewma * math.Exp(-float64(elapsed)/float64(b.decay))
+}
+
+func (b *p2cBalancer) minLatency() float64 {
+	min := 0.0
+	for _, stat := range b.stats {
+		if min == 0 || stat.ewma < min {
+			min = stat.ewma
+		}
+	}
+	return min
+}
+
+func (b *p2cBalancer) Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error) {
+	switch len(pools) {
+	case 0:
+		return nil, ErrNoEndpoint
+	case 1:
+		return pools[0], nil
+	}
+
+	b.lock.Lock()
+	if len(b.stats) > 2*len(pools) {
+		stats := make(map[*ThriftPool]*p2cStat, len(pools))
+		for _, p := range pools {
+			if stat, ok := b.stats[p]; ok {
+				stats[p] = stat
+			}
+		}
+		b.stats = stats
+	}
+
+	i := b.rand.Intn(len(pools))
+	j := b.rand.Intn(len(pools) - 1)
+	if j >= i {
+		j += 1
+	}
+	now := nowFunc()
+	li, lj := b.latency(pools[i], now), b.latency(pools[j], now)
+	b.lock.Unlock()
+
+	si := (li + 1) * float64(pools[i].GetInUseCount()+1) / math.Max(pools[i].EffectiveWeight(), 1e-3)
+	sj := (lj + 1) * float64(pools[j].GetInUseCount()+1) / math.Max(pools[j].EffectiveWeight(), 1e-3)
+	if sj < si {
+		return pools[j], nil
+	}
+	return pools[i], nil
+}
+
+func (b *p2cBalancer) Observe(p *ThriftPool, latency time.Duration, failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := nowFunc()
+	stat, ok := b.stats[p]
+	if !ok {
+		stat = &p2cStat{ewma: float64(latency), last: now}
+		b.stats[p] = stat
+	}
+
+	sample := float64(latency)
+	elapsed := now.Sub(stat.last)
+	w := math.Exp(-float64(elapsed) / float64(b.decay))
+	//延迟升高时立即跟随, 降低时按时间衰减
+	if sample > stat.ewma {
+		stat.ewma = sample
+	} else {
+		stat.ewma = stat.ewma*w + sample*(1-w)
+	}
+	if failed && stat.ewma < float64(b.penalty) {
+		stat.ewma = float64(b.penalty)
+	}
+	stat.last = now
+}
@@ -0,0 +1,81 @@
+package thriftPool
+
+import (
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// 记录连接上最近一次调用的读写进度, 调用未完整结束时连接上残留着半个请求或未读的回复
+type callTracker struct {
+	seqId    int32
+	writing  bool //已开始写请求, 尚未Flush成功
+	awaiting bool //请求已发出, 尚未读完回复
+	reading  bool //已开始读回复, 尚未读到消息结束
+	mismatch bool //回复的seqid与请求不一致
+}
+
+func (t *callTracker) poisoned() bool {
+	return t.writing || t.awaiting || t.reading || t.mismatch
+}
+
+type trackedProtocolFactory struct {
+	factory thrift.TProtocolFactory
+	tracker *callTracker
+}
+
+func (f *trackedProtocolFactory) GetProtocol(trans thrift.TTransport) thrift.TProtocol {
+	return &trackedProtocol{TProtocol: f.factory.GetProtocol(trans), tracker: f.tracker}
+}
+
+type trackedProtocol struct {
+	thrift.TProtocol
+	tracker *callTracker
+}
+
+func (p *trackedProtocol) WriteMessageBegin(name string, typeId thrift.TMessageType, seqId int32) error {
+	t := p.tracker
+	t.writing, t.awaiting = true, typeId == thrift.CALL
+	t.seqId = seqId
+	return p.TProtocol.WriteMessageBegin(name, typeId, seqId)
+}
+
+func (p *trackedProtocol) Flush() error {
+	err := p.TProtocol.Flush()
+	if err == nil {
+		p.tracker.writing = false
+	}
+	return err
+}
+
+func (p *trackedProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	name, typeId, seqId, err := p.TProtocol.ReadMessageBegin()
+	p.tracker.reading = true
+	if err == nil && seqId != p.tracker.seqId {
+		p.tracker.mismatch = true
+	}
+	return name, typeId, seqId, err
+}
+
+func (p *trackedProtocol) ReadMessageEnd() error {
+	err := p.TProtocol.ReadMessageEnd()
+	if err == nil {
+		p.tracker.reading, p.tracker.awaiting = false, false
+	}
+	return err
+}
+
+// 创建Client时使用返回的protocol factory, 连接池据此判断调用是否完整结束:
+// 归还时有未Flush的请求、未读完的回复或seqid不一致的连接直接关闭
+//
+//	c := &thriftPool.IdleClient{Socket: socket}
+//	c.Client = tutorial.NewRpcServiceClientFactory(trans, c.TrackProtocol(protocolFactory))
+func (c *IdleClient) TrackProtocol(f thrift.TProtocolFactory) thrift.TProtocolFactory {
+	if c.tracker == nil {
+		c.tracker = &callTracker{}
+	}
+	return &trackedProtocolFactory{factory: f, tracker: c.tracker}
+}
+
+// 连接上的数据流是否已不完整, 未使用TrackProtocol时只能发现被Call中断的连接
+func (c *IdleClient) Poisoned() bool {
+	return c.broken || c.tracker != nil && c.tracker.poisoned()
+}
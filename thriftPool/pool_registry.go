@@ -0,0 +1,305 @@
+package thriftPool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrServiceNotFound = errors.New("服务没有配置连接池")
+
+// 按服务名管理的多个MapPool, 由YAML配置创建
+type Registry struct {
+	lock      *sync.Mutex
+	factories map[string]ClientFactory
+	configs   map[string]PoolConfig
+	pools     map[string]*MapPool
+
+	watchStop   chan struct{}
+	reloadHooks []func(err error)
+}
+
+// factories按服务名提供thrift client的构造函数
+func NewRegistry(factories map[string]ClientFactory) *Registry {
+	return &Registry{
+		lock:      new(sync.Mutex),
+		factories: factories,
+		configs:   make(map[string]PoolConfig),
+		pools:     make(map[string]*MapPool),
+	}
+}
+
+// 读取path的配置并创建全部连接池
+func LoadRegistry(path string, factories map[string]ClientFactory) (*Registry, error) {
+	cfg, err := LoadRegistryConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry(factories)
+	if err := r.Apply(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 按cfg更新连接池: 新增的服务创建连接池, 删除的服务释放连接池(借出的连接归还时关闭),
+// 已有的服务原地调整限制、超时与地址, dial配置只影响之后新建的连接;
+// 配置无效或任一服务准备失败时不做任何改动
+func (r *Registry) Apply(cfg *RegistryConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	type change struct {
+		dialer   *configDialer
+		balancer Balancer
+	}
+	added := make(map[string]*MapPool)
+	changed := make(map[string]change)
+	for name, poolCfg := range cfg.Services {
+		old, ok := r.configs[name]
+		if !ok {
+			mp, err := r.newMapPool(name, poolCfg)
+			if err != nil {
+				for _, mp := range added {
+					mp.ReleaseAll()
+				}
+				return err
+			}
+			added[name] = mp
+			continue
+		}
+
+		var c change
+		var err error
+		if old.Transport != poolCfg.Transport || old.Protocol != poolCfg.Protocol || !reflect.DeepEqual(old.TLS, poolCfg.TLS) {
+			c.dialer, err = r.newDialer(name, poolCfg)
+		}
+		if err == nil && old.Balancer != poolCfg.Balancer {
+			c.balancer, err = newBalancer(poolCfg.Balancer)
+		}
+		if err != nil {
+			for _, mp := range added {
+				mp.ReleaseAll()
+			}
+			return fmt.Errorf("服务%s: %v", name, err)
+		}
+		changed[name] = c
+	}
+
+	for name, mp := range r.pools {
+		if _, ok := cfg.Services[name]; !ok {
+			delete(r.pools, name)
+			delete(r.configs, name)
+			mp.ReleaseAll()
+		}
+	}
+	for name, mp := range added {
+		r.pools[name] = mp
+		r.configs[name] = cfg.Services[name]
+	}
+	for name, c := range changed {
+		update(r.pools[name], r.configs[name], cfg.Services[name], c.dialer, c.balancer)
+		r.configs[name] = cfg.Services[name]
+	}
+	return nil
+}
+
+func (r *Registry) newDialer(name string, cfg PoolConfig) (*configDialer, error) {
+	factory, ok := r.factories[name]
+	if !ok {
+		return nil, fmt.Errorf("服务%s没有对应的ClientFactory", name)
+	}
+	return newConfigDialer(cfg, factory)
+}
+
+func (r *Registry) newMapPool(name string, cfg PoolConfig) (*MapPool, error) {
+	dialer, err := r.newDialer(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	balancer, err := newBalancer(cfg.Balancer)
+	if err != nil {
+		return nil, fmt.Errorf("服务%s: %v", name, err)
+	}
+
+	mp := NewMapPool(cfg.MaxConn, cfg.ConnTimeout, cfg.IdleTimeout, nil, closeIdleClient)
+	update(mp, PoolConfig{}, cfg, dialer, balancer)
+	return mp, nil
+}
+
+// 将old与cfg的差异应用到mp, dialer、balancer为nil时不变
+func update(mp *MapPool, old, cfg PoolConfig, dialer *configDialer, balancer Balancer) {
+	if dialer != nil {
+		mp.SetDial(nil, dialer.dial)
+	}
+	if balancer != nil {
+		mp.SetBalancer(balancer)
+	}
+	if old.MaxConn != cfg.MaxConn {
+		mp.SetMaxConn(cfg.MaxConn)
+	}
+	if old.timeouts() != cfg.timeouts() {
+		mp.SetTimeouts(cfg.timeouts())
+	}
+	if old.IdleTimeout != cfg.IdleTimeout {
+		mp.SetIdleTimeout(cfg.IdleTimeout)
+	}
+	if old.MaxDialing != cfg.MaxDialing {
+		if cfg.MaxDialing > 0 {
+			mp.SetMaxDialing(cfg.MaxDialing)
+		} else {
+			mp.SetMaxDialing(MAXDIALING)
+		}
+	}
+	if old.MaxTotalConn != cfg.MaxTotalConn {
+		mp.SetMaxTotalConn(cfg.MaxTotalConn)
+	}
+	if !reflect.DeepEqual(old.RateLimit, cfg.RateLimit) {
+		rateLimit := RateLimit{}
+		if cfg.RateLimit != nil {
+			rateLimit = RateLimit{Rate: cfg.RateLimit.Rate, Burst: cfg.RateLimit.Burst, Mode: LimitWait}
+			if cfg.RateLimit.Reject {
+				rateLimit.Mode = LimitReject
+			}
+		}
+		mp.SetRateLimit(rateLimit)
+	}
+	if !reflect.DeepEqual(old.Endpoints, cfg.Endpoints) {
+		//移除的地址等借出的连接归还后释放
+		targets := make([]Target, 0, len(cfg.Endpoints))
+		for _, addr := range cfg.Endpoints {
+			e, _ := ParseEndpoint(addr)
+			host, port := e.HostPort()
+			targets = append(targets, Target{Network: e.Network, Host: host, Port: port})
+		}
+		mp.UpdateTargets(targets)
+	}
+}
+
+func newBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", "round_robin":
+		return NewRoundRobinBalancer(), nil
+	case "random":
+		return NewRandomBalancer(), nil
+	case "least_in_use":
+		return NewLeastInUseBalancer(), nil
+	case "p2c":
+		return NewP2CBalancer(0, 0), nil
+	case "ring_hash":
+		return NewRingHashBalancer(0, 0), nil
+	}
+	return nil, fmt.Errorf("未知的balancer: %s", name)
+}
+
+// 按服务名获取连接池
+func (r *Registry) Get(service string) (*MapPool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	mp, ok := r.pools[service]
+	if !ok {
+		return nil, fmt.Errorf("%s: %v", service, ErrServiceNotFound)
+	}
+	return mp, nil
+}
+
+func (r *Registry) Services() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.pools))
+	for name := range r.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 每次重新加载配置后回调, err非nil时旧配置保持不变
+func (r *Registry) OnReload(hook func(err error)) {
+	r.lock.Lock()
+	r.reloadHooks = append(r.reloadHooks, hook)
+	r.lock.Unlock()
+}
+
+// 读取path的配置并应用
+func (r *Registry) Reload(path string) error {
+	cfg, err := LoadRegistryConfig(path)
+	if err == nil {
+		err = r.Apply(cfg)
+	}
+
+	r.lock.Lock()
+	hooks := r.reloadHooks
+	r.lock.Unlock()
+	for _, hook := range hooks {
+		hook(err)
+	}
+	return err
+}
+
+// 收到SIGHUP或每interval检查到path的内容变化时重新加载配置
+func (r *Registry) WatchConfig(path string, interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	r.lock.Lock()
+	if r.watchStop != nil {
+		close(r.watchStop)
+	}
+	r.watchStop = make(chan struct{})
+	stop := r.watchStop
+	r.lock.Unlock()
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, _ := ioutil.ReadFile(path)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+			case <-ticker.C:
+				data, err := ioutil.ReadFile(path)
+				if err != nil || bytes.Equal(data, last) {
+					continue
+				}
+			}
+			//无效的配置同样记录, 文件再次变化前不重复加载
+			last, _ = ioutil.ReadFile(path)
+			r.Reload(path)
+		}
+	}()
+}
+
+func (r *Registry) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.watchStop != nil {
+		close(r.watchStop)
+		r.watchStop = nil
+	}
+	for name, mp := range r.pools {
+		mp.ReleaseAll()
+		delete(r.pools, name)
+		delete(r.configs, name)
+	}
+}
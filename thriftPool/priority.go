@@ -0,0 +1,97 @@
+package thriftPool
+
+import (
+	"time"
+)
+
+const (
+	FAILBACKDELAY = 30 * time.Second //更高优先级组恢复健康后持续稳定多久才切回
+)
+
+// 数值越小优先级越高, 0为主组
+func (p *ThriftPool) SetPriority(priority int) {
+	if priority < 0 {
+		priority = 0
+	}
+	p.lock.Lock()
+	p.priority = priority
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) Priority() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.priority
+}
+
+func (mp *MapPool) SetFailback(delay time.Duration) {
+	mp.lock.Lock()
+	mp.failback = delay
+	mp.lock.Unlock()
+}
+
+// 当前使用的优先级组切换时回调, 在借用的goroutine中同步执行
+func (mp *MapPool) OnFailover(hook func(from, to int)) {
+	mp.lock.Lock()
+	mp.failoverHooks = append(mp.failoverHooks, hook)
+	mp.lock.Unlock()
+}
+
+// 当前优先级组, 尚未发生借用时为-1
+func (mp *MapPool) ActivePriority() int {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	return mp.activePriority
+}
+
+// 当前组没有健康地址时立即切换到有健康地址的最高优先级组;
+// 更高优先级组恢复后需持续健康failback时长才切回
+func (mp *MapPool) selectPriority(healthy []*ThriftPool) int {
+	best, activeHealthy := -1, false
+
+	mp.lock.Lock()
+	active := mp.activePriority
+	for _, p := range healthy {
+		priority := p.Priority()
+		if best < 0 || priority < best {
+			best = priority
+		}
+		if priority == active {
+			activeHealthy = true
+		}
+	}
+
+	next := active
+	switch {
+	case active < 0:
+		next = best
+	case !activeHealthy:
+		next = best
+	case best < active:
+		now := nowFunc()
+		if mp.betterSince.IsZero() {
+			mp.betterSince = now
+		}
+		if now.Sub(mp.betterSince) >= mp.failback {
+			next = best
+		}
+	default:
+		mp.betterSince = time.Time{}
+	}
+
+	if next == active {
+		mp.lock.Unlock()
+		return active
+	}
+	mp.activePriority = next
+	mp.betterSince = time.Time{}
+	hooks := mp.failoverHooks
+	mp.lock.Unlock()
+
+	if active >= 0 {
+		for _, hook := range hooks {
+			hook(active, next)
+		}
+	}
+	return next
+}
@@ -0,0 +1,134 @@
+package thriftPool
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+type LimitMode int
+
+const (
+	LimitWait   LimitMode = iota //令牌不足时等待, 受ctx控制
+	LimitReject                  //令牌不足时直接返回ErrRateLimited
+)
+
+// Rate为每秒令牌数, Rate<=0表示不限流; Methods按thrift方法名覆盖默认配置
+type RateLimit struct {
+	Rate    float64
+	Burst   int
+	Mode    LimitMode
+	Methods map[string]RateLimit
+}
+
+var ErrRateLimited = errors.New("请求超过限流速率")
+
+type tokenBucket struct {
+	lock   sync.Mutex
+	mode   LimitMode
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(cfg RateLimit) *tokenBucket {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		mode:   cfg.Mode,
+		rate:   cfg.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   nowFunc(),
+	}
+}
+
+// 取一个令牌并返回需要等待的时间, 令牌可以透支; 拒绝模式下令牌不足时不扣减
+func (b *tokenBucket) reserve() (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := nowFunc()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return 0, true
+	}
+	if b.mode == LimitReject {
+		return 0, false
+	}
+
+	b.tokens -= 1
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+func (b *tokenBucket) cancel() {
+	b.lock.Lock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+	b.lock.Unlock()
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d, ok := b.reserve()
+	if !ok {
+		return ErrRateLimited
+	}
+	if d == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(nowFunc().Add(d)) {
+		b.cancel()
+		return ErrRateLimited
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+type RateLimiter struct {
+	bucket  *tokenBucket
+	methods map[string]*tokenBucket
+}
+
+func NewRateLimiter(cfg RateLimit) *RateLimiter {
+	l := &RateLimiter{
+		bucket:  newTokenBucket(cfg),
+		methods: make(map[string]*tokenBucket, len(cfg.Methods)),
+	}
+	for method, mcfg := range cfg.Methods {
+		l.methods[method] = newTokenBucket(mcfg)
+	}
+	return l
+}
+
+// 配置了方法级限流的method只消耗其自身的令牌, 其余使用默认令牌桶
+func (l *RateLimiter) Wait(ctx context.Context, method string) error {
+	bucket, ok := l.methods[method]
+	if !ok {
+		bucket = l.bucket
+	}
+	if bucket == nil {
+		return nil
+	}
+	return bucket.wait(ctx)
+}
@@ -0,0 +1,111 @@
+package thriftPool
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// 地址集合变化后逐步均衡各地址的连接数: 每个Interval按有效权重计算各地址应占的连接数,
+// 超出(1+Tolerance)倍的地址关闭多余的空闲连接, 每个Interval合计最多关闭MaxRetire个
+type Rebalance struct {
+	Interval  time.Duration
+	MaxRetire uint32
+	Tolerance float64
+}
+
+var DefaultRebalance = Rebalance{
+	Interval:  10 * time.Second,
+	MaxRetire: 4,
+	Tolerance: 0.2,
+}
+
+// 传入nil关闭
+func (mp *MapPool) SetRebalance(cfg *Rebalance) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if mp.rebalanceStop != nil {
+		close(mp.rebalanceStop)
+		mp.rebalanceStop = nil
+	}
+	if cfg == nil {
+		return
+	}
+	c := *cfg
+	if c.Interval <= 0 {
+		c.Interval = DefaultRebalance.Interval
+	}
+	mp.rebalanceStop = make(chan struct{})
+	go mp.runRebalance(c, mp.rebalanceStop)
+}
+
+func (mp *MapPool) runRebalance(cfg Rebalance, stop chan struct{}) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			mp.rebalance(cfg)
+		}
+	}
+}
+
+// 返回本次关闭的连接数
+func (mp *MapPool) rebalance(cfg Rebalance) uint32 {
+	var pools []*ThriftPool
+	for _, p := range mp.Endpoints() {
+		if !p.Draining() {
+			pools = append(pools, p)
+		}
+	}
+	if len(pools) < 2 {
+		return 0
+	}
+
+	type surplus struct {
+		pool   *ThriftPool
+		excess float64
+	}
+	weights := effectiveWeights(pools)
+	totalWeight, totalConn := 0.0, 0.0
+	counts := make([]float64, len(pools))
+	for i, p := range pools {
+		counts[i] = float64(p.GetConnCount())
+		totalWeight += weights[i]
+		totalConn += counts[i]
+	}
+
+	var over []surplus
+	for i, p := range pools {
+		target := totalConn * weights[i] / totalWeight
+		if excess := counts[i] - math.Ceil(target*(1+cfg.Tolerance)); excess >= 1 {
+			over = append(over, surplus{pool: p, excess: excess})
+		}
+	}
+	sort.Slice(over, func(i, j int) bool { return over[i].excess > over[j].excess })
+
+	//多个地址超出时轮流关闭, 避免集中在同一个地址
+	retired := uint32(0)
+	for retired < cfg.MaxRetire {
+		progress := false
+		for i := range over {
+			if retired >= cfg.MaxRetire {
+				break
+			}
+			if over[i].excess < 1 || !over[i].pool.reclaimIdle() {
+				continue
+			}
+			over[i].excess -= 1
+			retired += 1
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+	return retired
+}
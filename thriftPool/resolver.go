@@ -0,0 +1,321 @@
+package thriftPool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DRAINTIMEOUT = 30 * time.Second //移除地址时等待借出连接归还的最长时间
+)
+
+// 解析得到的服务地址, Weight为0时按1处理; Network为unix时Host为socket路径
+type Target struct {
+	Network  string `json:"network,omitempty" yaml:"network,omitempty"`
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	Weight   uint32 `json:"weight" yaml:"weight"`
+	Zone     string `json:"zone" yaml:"zone"`
+	Priority int    `json:"priority" yaml:"priority"`
+}
+
+func (t Target) Endpoint() Endpoint {
+	if t.Network == "unix" {
+		return UnixEndpoint(t.Host)
+	}
+	return TCPEndpoint(t.Host, t.Port)
+}
+
+func (t Target) Addr() string {
+	return t.Endpoint().String()
+}
+
+// Watch阻塞运行直到ctx结束, 每当地址集合变化时以全量地址调用update
+type Resolver interface {
+	Watch(ctx context.Context, update func([]Target)) error
+}
+
+// 启动resolver并用其结果更新地址集合, 传入nil停止当前resolver
+func (mp *MapPool) SetResolver(resolver Resolver) {
+	mp.lock.Lock()
+	if mp.resolverCancel != nil {
+		mp.resolverCancel()
+		mp.resolverCancel = nil
+	}
+	if resolver == nil {
+		mp.lock.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	mp.resolverCancel = cancel
+	mp.lock.Unlock()
+
+	go resolver.Watch(ctx, mp.UpdateTargets)
+}
+
+// 新加入的地址预先建立的连接数
+func (mp *MapPool) SetPrewarm(n uint32) {
+	mp.lock.Lock()
+	mp.prewarm = n
+	mp.lock.Unlock()
+}
+
+// 以全量地址更新注册的地址集合: 新地址注册并预热, 已移除的地址等借出连接归还后释放;
+// 设置了SetSubset时只注册其中的子集
+func (mp *MapPool) UpdateTargets(targets []Target) {
+	mp.updateLock.Lock()
+	defer mp.updateLock.Unlock()
+
+	mp.lock.Lock()
+	mp.targets = targets
+	targets = mp.subset(targets)
+	mp.lock.Unlock()
+
+	wanted := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		wanted[t.Addr()] = t
+	}
+
+	for _, p := range mp.Endpoints() {
+		if _, ok := wanted[p.addr()]; !ok {
+			mp.retire(p)
+		}
+	}
+
+	mp.lock.Lock()
+	prewarm := mp.prewarm
+	mp.lock.Unlock()
+
+	for _, t := range targets {
+		_, err := mp.getEndpointPool(t.Endpoint())
+		isNew := err != nil
+
+		p := mp.RegisterEndpoint(t.Endpoint())
+		weight := t.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		p.SetWeight(weight)
+		p.SetZone(t.Zone)
+		p.SetPriority(t.Priority)
+
+		if isNew && prewarm > 0 {
+			go p.warm(prewarm)
+		}
+	}
+}
+
+// 预先建立n个连接放入空闲队列
+func (p *ThriftPool) warm(n uint32) {
+	clients := make([]*IdleClient, 0, n)
+	for i := uint32(0); i < n; i++ {
+		c, err := p.get(context.Background())
+		if err != nil {
+			break
+		}
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		p.Put(c)
+	}
+}
+
+// 从地址集合中移除, 不再分配新的借用, 借出连接全部归还或超时后释放
+func (mp *MapPool) retire(p *ThriftPool) {
+	mp.lock.Lock()
+	mp.removeEndpoint(p)
+	mp.lock.Unlock()
+
+	go func() {
+		deadline := nowFunc().Add(DRAINTIMEOUT)
+		for p.GetInUseCount() > 0 && nowFunc().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		//期间被重新注册则保留
+		mp.lock.Lock()
+		current, ok := mp.pools[p.addr()]
+		for _, e := range mp.endpoints {
+			if e == p {
+				ok = false
+			}
+		}
+		if ok && current == p {
+			delete(mp.pools, p.addr())
+		} else {
+			ok = false
+		}
+		mp.lock.Unlock()
+
+		if ok {
+			p.Release()
+		}
+	}()
+}
+
+type staticResolver struct {
+	targets []Target
+}
+
+func NewStaticResolver(targets ...Target) Resolver {
+	return &staticResolver{targets: targets}
+}
+
+func (r *staticResolver) Watch(ctx context.Context, update func([]Target)) error {
+	update(r.targets)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type fileResolver struct {
+	path     string
+	interval time.Duration
+}
+
+// 定期读取JSON或YAML(.yaml/.yml)格式的地址列表文件, 内容变化时更新; 解析失败时保留上一次的结果
+func NewFileResolver(path string, interval time.Duration) Resolver {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &fileResolver{path: path, interval: interval}
+}
+
+func (r *fileResolver) load() ([]byte, []Target, error) {
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		//文件正在被改写
+		return nil, nil, fmt.Errorf("地址文件%s为空", r.path)
+	}
+	var targets []Target
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &targets)
+	default:
+		err = json.Unmarshal(data, &targets)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析地址文件%s失败: %v", r.path, err)
+	}
+	return data, targets, nil
+}
+
+func (r *fileResolver) Watch(ctx context.Context, update func([]Target)) error {
+	var last []byte
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if data, targets, err := r.load(); err == nil && (last == nil || !bytes.Equal(data, last)) {
+			last = data
+			update(targets)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// 定期查询DNS: Service非空时查询SRV记录(_service._proto.name), 否则查询name的A/AAAA记录并使用Port
+type DNSResolver struct {
+	Name     string
+	Port     string
+	Service  string
+	Proto    string
+	Interval time.Duration
+	Resolver *net.Resolver //nil时使用net.DefaultResolver
+}
+
+func NewDNSResolver(name, port string, interval time.Duration) *DNSResolver {
+	return &DNSResolver{Name: name, Port: port, Interval: interval}
+}
+
+func NewSRVResolver(service, proto, name string, interval time.Duration) *DNSResolver {
+	return &DNSResolver{Name: name, Service: service, Proto: proto, Interval: interval}
+}
+
+func (r *DNSResolver) lookup(ctx context.Context) ([]Target, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var targets []Target
+	if r.Service != "" {
+		_, srvs, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			targets = append(targets, Target{
+				Host:     strings.TrimSuffix(srv.Target, "."),
+				Port:     strconv.Itoa(int(srv.Port)),
+				Weight:   uint32(srv.Weight),
+				Priority: int(srv.Priority),
+			})
+		}
+	} else {
+		addrs, err := resolver.LookupIPAddr(ctx, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			targets = append(targets, Target{Host: addr.IP.String(), Port: r.Port})
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Addr() < targets[j].Addr() })
+	return targets, nil
+}
+
+func (r *DNSResolver) Watch(ctx context.Context, update func([]Target)) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []Target
+	for {
+		//查询失败或结果为空时保留上一次的地址
+		if targets, err := r.lookup(ctx); err == nil && len(targets) > 0 && !sameTargets(targets, last) {
+			last = targets
+			update(targets)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameTargets(a, b []Target) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,344 @@
+package thriftPool
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func endpointAddrs(mp *MapPool) []string {
+	var addrs []string
+	for _, p := range mp.Endpoints() {
+		addrs = append(addrs, p.Stats().Addr)
+	}
+	sort.Strings(addrs)
+	return strings.Split(strings.Join(addrs, ","), ",")
+}
+
+func waitEndpoints(t *testing.T, mp *MapPool, want ...string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := endpointAddrs(mp)
+		if strings.Join(got, ",") == strings.Join(want, ",") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints:%v, want:%v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStaticResolver(t *testing.T) {
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+
+	mp.SetResolver(NewStaticResolver(
+		Target{Host: "127.0.0.1", Port: "9000", Weight: 3, Zone: "bj"},
+		Target{Host: "127.0.0.1", Port: "9001", Priority: 1},
+	))
+	waitEndpoints(t, mp, "127.0.0.1:9000", "127.0.0.1:9001")
+
+	s := mp.Stats()
+	if s["127.0.0.1:9000"].Weight != 3 || s["127.0.0.1:9000"].Zone != "bj" || s["127.0.0.1:9001"].Priority != 1 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := mp.Get("127.0.0.1", "9000").GetIdleCount(); n != 1 {
+		t.Fatalf("prewarm idle count:%d is err", n)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thriftpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "endpoints.yaml")
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("- {host: 127.0.0.1, port: \"9000\"}\n- {host: 127.0.0.1, port: \"9001\"}\n")
+
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetResolver(NewFileResolver(path, 10*time.Millisecond))
+	waitEndpoints(t, mp, "127.0.0.1:9000", "127.0.0.1:9001")
+
+	removed := mp.Get("127.0.0.1", "9000")
+	c, err := removed.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+
+	write("- {host: 127.0.0.1, port: \"9001\"}\n- {host: 127.0.0.1, port: \"9002\"}\n")
+	waitEndpoints(t, mp, "127.0.0.1:9001", "127.0.0.1:9002")
+
+	//解析失败时保留原地址
+	write("- {host: [")
+	time.Sleep(50 * time.Millisecond)
+	waitEndpoints(t, mp, "127.0.0.1:9001", "127.0.0.1:9002")
+
+	//借出的连接归还后才释放被移除的地址
+	if _, err := mp.getServerPool("127.0.0.1", "9000"); err != nil {
+		t.Fatalf("removed endpoint released before drained")
+	}
+	removed.Put(c)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := mp.getServerPool("127.0.0.1", "9000"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("removed endpoint not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 只应答A与SRV查询的本地DNS服务
+type fakeDNS struct {
+	lock    sync.Mutex
+	records map[string][]net.IP
+	srvs    map[string][]net.SRV
+	conn    net.PacketConn
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDNS{
+		records: make(map[string][]net.IP),
+		srvs:    make(map[string][]net.SRV),
+		conn:    conn,
+	}
+	go d.serve()
+	return d
+}
+
+func (d *fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", d.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (d *fakeDNS) set(name string, ips ...string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.records[name] = nil
+	for _, ip := range ips {
+		d.records[name] = append(d.records[name], net.ParseIP(ip).To4())
+	}
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func (d *fakeDNS) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+
+		//解析question
+		off := 12
+		var labels []string
+		for off < n && req[off] != 0 {
+			l := int(req[off])
+			labels = append(labels, string(req[off+1:off+1+l]))
+			off += 1 + l
+		}
+		off += 1
+		qtype := binary.BigEndian.Uint16(req[off:])
+		question := req[12 : off+4]
+		name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+		var answers [][]byte
+		d.lock.Lock()
+		switch qtype {
+		case 1: //A
+			for _, ip := range d.records[name] {
+				answers = append(answers, append([]byte{0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 1, 0, 4}, ip...))
+			}
+		case 33: //SRV
+			for _, srv := range d.srvs[name] {
+				target := encodeName(srv.Target)
+				rr := []byte{0xc0, 0x0c, 0, 33, 0, 1, 0, 0, 0, 1, 0, 0}
+				binary.BigEndian.PutUint16(rr[10:], uint16(6+len(target)))
+				rr = append(rr, 0, 0, 0, 0, 0, 0)
+				binary.BigEndian.PutUint16(rr[12:], srv.Priority)
+				binary.BigEndian.PutUint16(rr[14:], srv.Weight)
+				binary.BigEndian.PutUint16(rr[16:], srv.Port)
+				answers = append(answers, append(rr, target...))
+			}
+		}
+		d.lock.Unlock()
+
+		resp := []byte{req[0], req[1], 0x81, 0x80, 0, 1, 0, byte(len(answers)), 0, 0, 0, 0}
+		resp = append(resp, question...)
+		for _, rr := range answers {
+			resp = append(resp, rr...)
+		}
+		d.conn.WriteTo(resp, addr)
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+	dns := newFakeDNS(t)
+	defer dns.conn.Close()
+	dns.set("thrift.test.", "127.0.0.1", "127.0.0.2")
+
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetPrewarm(0)
+
+	resolver := NewDNSResolver("thrift.test.", "9000", 10*time.Millisecond)
+	resolver.Resolver = dns.resolver()
+	mp.SetResolver(resolver)
+	waitEndpoints(t, mp, "127.0.0.1:9000", "127.0.0.2:9000")
+
+	dns.set("thrift.test.", "127.0.0.2", "127.0.0.3")
+	waitEndpoints(t, mp, "127.0.0.2:9000", "127.0.0.3:9000")
+}
+
+func TestSRVResolver(t *testing.T) {
+	dns := newFakeDNS(t)
+	defer dns.conn.Close()
+	dns.lock.Lock()
+	dns.srvs["_thrift._tcp.svc.test."] = []net.SRV{
+		{Target: "a.svc.test.", Port: 9000, Priority: 0, Weight: 5},
+		{Target: "b.svc.test.", Port: 9001, Priority: 1, Weight: 1},
+	}
+	dns.lock.Unlock()
+
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetPrewarm(0)
+
+	resolver := NewSRVResolver("thrift", "tcp", "svc.test.", 10*time.Millisecond)
+	resolver.Resolver = dns.resolver()
+	mp.SetResolver(resolver)
+	waitEndpoints(t, mp, "a.svc.test:9000", "b.svc.test:9001")
+
+	s := mp.Stats()
+	if s["a.svc.test:9000"].Weight != 5 || s["b.svc.test:9001"].Priority != 1 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+}
+
+func TestSubset(t *testing.T) {
+	var targets []Target
+	for i := 0; i < 20; i++ {
+		targets = append(targets, Target{Host: "10.0.0.1", Port: strconv.Itoa(9000 + i)})
+	}
+
+	load := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		mp := &MapPool{subsetID: "client-" + strconv.Itoa(i), subsetSize: 5}
+		picked := mp.subset(targets)
+		if len(picked) != 5 {
+			t.Fatalf("subset size:%d is err", len(picked))
+		}
+		before := make(map[string]bool)
+		for _, p := range picked {
+			load[p.Addr()] += 1
+			before[p.Addr()] = true
+		}
+
+		//移除一个地址最多替换子集中的一个地址
+		changed := 0
+		for _, p := range mp.subset(targets[1:]) {
+			if !before[p.Addr()] {
+				changed += 1
+			}
+		}
+		if changed > 1 {
+			t.Fatalf("subset churn:%d is err", changed)
+		}
+	}
+	//期望每个地址被50个客户端选中
+	for addr, n := range load {
+		if n < 25 || n > 75 {
+			t.Fatalf("addr:%s picked by %d clients, load:%v", addr, n, load)
+		}
+	}
+
+	//增减一个地址时只有包含该地址的子集变化, 100个客户端各选10个, 期望约10个位置变化
+	targets = targets[:0]
+	for i := 0; i <= 100; i++ {
+		targets = append(targets, Target{Host: "10.0.1.1", Port: strconv.Itoa(9000 + i)})
+	}
+	for _, c := range []struct {
+		before, after []Target
+	}{
+		{targets[:100], targets},
+		{targets[:100], targets[1:100]},
+	} {
+		moved := 0
+		for i := 0; i < 100; i++ {
+			mp := &MapPool{subsetID: strconv.Itoa(i), subsetSize: 10}
+			before := make(map[string]bool)
+			for _, p := range mp.subset(c.before) {
+				before[p.Addr()] = true
+			}
+			for _, p := range mp.subset(c.after) {
+				if !before[p.Addr()] {
+					moved += 1
+				}
+			}
+		}
+		if moved > 20 {
+			t.Fatalf("%d of 1000 subset slots moved", moved)
+		}
+	}
+	//子集与地址顺序无关
+	a := (&MapPool{subsetID: "7", subsetSize: 10}).subset(targets)
+	b := (&MapPool{subsetID: "7", subsetSize: 10}).subset(append([]Target{targets[100]}, targets[:100]...))
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("subset depends on target order")
+		}
+	}
+
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetPrewarm(0)
+	mp.UpdateTargets(targets[:20])
+	if n := len(mp.Endpoints()); n != 20 {
+		t.Fatalf("endpoints:%d is err", n)
+	}
+	if err := mp.SetSubset("", 3); err != ErrEmptySubsetID {
+		t.Fatalf("empty clientID err:%v", err)
+	}
+	mp.SetSubset("client-0", 3)
+	if n := len(mp.Endpoints()); n != 3 {
+		t.Fatalf("endpoints after subset:%d is err", n)
+	}
+}
@@ -0,0 +1,127 @@
+package thriftPool
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	RINGREPLICAS = 160 //每个地址在哈希环上的虚拟节点数
+)
+
+type hashKey struct{}
+
+// 为本次借用附加一致性哈希的路由key, 例如用户ID
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+type ringNode struct {
+	hash uint64
+	pool *ThriftPool
+}
+
+type ringHashBalancer struct {
+	lock       *sync.Mutex
+	replicas   int
+	loadFactor float64
+	pools      []*ThriftPool
+	ring       []ringNode
+}
+
+// 按WithHashKey传入的key在哈希环上选择地址, 地址增减时只有相邻区间的key迁移;
+// loadFactor>1时开启有界负载: 地址借出数超过 loadFactor*平均借出数 时顺延到环上下一个地址
+func NewRingHashBalancer(replicas int, loadFactor float64) Balancer {
+	if replicas <= 0 {
+		replicas = RINGREPLICAS
+	}
+	return &ringHashBalancer{
+		lock:       new(sync.Mutex),
+		replicas:   replicas,
+		loadFactor: loadFactor,
+	}
+}
+
+// 需持有b.lock, 地址集合变化时重建哈希环
+func (b *ringHashBalancer) build(pools []*ThriftPool) {
+	if len(pools) == len(b.pools) {
+		same := true
+		for i := range pools {
+			if pools[i] != b.pools[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+
+	ring := make([]ringNode, 0, len(pools)*b.replicas)
+	for _, p := range pools {
+		addr := p.addr()
+		for i := 0; i < b.replicas; i++ {
+			ring = append(ring, ringNode{hash: hash64(addr + "#" + strconv.Itoa(i)), pool: p})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	b.pools = append([]*ThriftPool(nil), pools...)
+	b.ring = ring
+}
+
+func (b *ringHashBalancer) Pick(ctx context.Context, pools []*ThriftPool) (*ThriftPool, error) {
+	if len(pools) == 0 {
+		return nil, ErrNoEndpoint
+	}
+
+	b.lock.Lock()
+	b.build(pools)
+	ring := b.ring
+	b.lock.Unlock()
+
+	var h uint64
+	if key, ok := HashKeyFromContext(ctx); ok {
+		h = hash64(key)
+	} else {
+		h = rand.Uint64()
+	}
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+
+	if b.loadFactor <= 1 {
+		return ring[start%len(ring)].pool, nil
+	}
+
+	var total uint32
+	inUse := make(map[*ThriftPool]uint32, len(pools))
+	for _, p := range pools {
+		n := p.GetInUseCount()
+		inUse[p] = n
+		total += n
+	}
+	capacity := uint32(math.Ceil(b.loadFactor * float64(total+1) / float64(len(pools))))
+
+	for i := 0; i < len(ring); i++ {
+		p := ring[(start+i)%len(ring)].pool
+		if inUse[p] < capacity {
+			return p, nil
+		}
+	}
+	return ring[start%len(ring)].pool, nil
+}
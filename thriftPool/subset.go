@@ -0,0 +1,61 @@
+package thriftPool
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrEmptySubsetID = errors.New("地址子集的clientID不能为空")
+
+// 每个客户端只连接resolver结果中的size个地址, size<=0关闭;
+// 按clientID与地址的rendezvous hash选取, 同一clientID的子集稳定, 地址增减时只影响包含该地址的子集;
+// 各地址被选中的客户端数只是统计上均匀
+func (mp *MapPool) SetSubset(clientID string, size int) error {
+	if size > 0 && clientID == "" {
+		//所有客户端会选中相同的子集
+		return ErrEmptySubsetID
+	}
+
+	mp.lock.Lock()
+	mp.subsetID, mp.subsetSize = clientID, size
+	targets := mp.targets
+	mp.lock.Unlock()
+
+	if targets != nil {
+		mp.UpdateTargets(targets)
+	}
+	return nil
+}
+
+// 需持有mp.lock
+func (mp *MapPool) subset(targets []Target) []Target {
+	if mp.subsetSize <= 0 || len(targets) <= mp.subsetSize {
+		return targets
+	}
+
+	type scored struct {
+		target Target
+		score  uint64
+	}
+	all := make([]scored, len(targets))
+	for i, t := range targets {
+		all[i] = scored{target: t, score: mix64(hash64(mp.subsetID + "|" + t.Addr()))}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+
+	picked := make([]Target, mp.subsetSize)
+	for i := range picked {
+		picked[i] = all[i].target
+	}
+	return picked
+}
+
+// fnv对相近的字符串分布不够均匀, 再做一次splitmix64混合
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -2,8 +2,10 @@ package thriftPool
 
 import (
 	"container/list"
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
@@ -13,29 +15,131 @@ import (
 
 const (
 	CHECKINTERVAL = 60 //清除超时连接间隔
+	MAXDIALING    = 4  //单个地址同时进行中的Dial数上限
 )
 
 type ThriftDial func(ip, port string, connTimeout time.Duration) (*IdleClient, error)
 type ThriftClientClose func(c *IdleClient) error
 
 type ThriftPool struct {
-	Dial  ThriftDial
-	Close ThriftClientClose
+	Dial         ThriftDial
+	Close        ThriftClientClose
+	DialEndpoint EndpointDial //非nil时优先于Dial
 
 	lock        *sync.Mutex
 	idle        list.List
 	idleTimeout time.Duration
-	connTimeout time.Duration
+	connTimeout time.Duration //建立连接超时
 	maxConn     uint32
 	count       uint32
 	ip          string
 	port        string
+	endpoint    Endpoint
 	closed      bool
+	stop        chan struct{}
+
+	//读写超时, 借出的client可单独修改, Put时恢复
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	//dial并发控制与失败退避
+	dialing     uint32
+	maxDialing  uint32
+	backoff     DialBackoff
+	dialFails   uint32
+	failSeq     uint64
+	dialErr     error
+	unavailable *UnavailableError
+	notify      chan struct{}
+
+	limiter  *RateLimiter
+	adaptive *AdaptiveLimiter
+	observer func(p *ThriftPool, latency time.Duration, failed bool)
+
+	//负载均衡权重与慢启动
+	weight       uint32
+	rampStart    time.Time
+	slowStart    time.Duration
+	slowStartMin float64
+	zone         string
+	priority     int
+
+	ejectedUntil time.Time
+
+	//摘除流量, 全部连接关闭后关闭drained
+	draining bool
+	drained  chan struct{}
+
+	//MapPool共享的总连接数上限
+	budget   *connBudget
+	lastUsed time.Time
+
+	poisoned uint32
+}
+
+// 连续Dial失败Threshold次后开始指数退避, 退避期内Get直接返回缓存的UnavailableError
+type DialBackoff struct {
+	Threshold uint32
+	Base      time.Duration
+	Max       time.Duration
+	Jitter    float64 //0~1, 退避时间的随机抖动比例
+}
+
+var DefaultDialBackoff = DialBackoff{
+	Threshold: 3,
+	Base:      100 * time.Millisecond,
+	Max:       30 * time.Second,
+	Jitter:    0.2,
+}
+
+func (b DialBackoff) delay(fails uint32) time.Duration {
+	if b.Threshold == 0 || fails < b.Threshold {
+		return 0
+	}
+	d := b.Base
+	for i := b.Threshold; i < fails && d < b.Max; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(d))
+	}
+	return d
+}
+
+type UnavailableError struct {
+	Addr    string
+	RetryAt time.Time
+	Err     error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Addr:%s %s, 重试时间:%s, 最近错误:%v",
+		e.Addr, ErrEndpointUnavailable, e.RetryAt.Format(time.RFC3339Nano), e.Err)
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrEndpointUnavailable
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
 }
 
 type IdleClient struct {
 	Socket *thrift.TSocket
 	Client interface{}
+
+	pool     *ThriftPool
+	borrowed time.Time
+	adaptive *AdaptiveLimiter
+	broken   bool //调用被中断, 数据流已不完整
+	tracker  *callTracker
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 type idleConn struct {
@@ -51,24 +155,46 @@ var (
 	ErrInvalidConn      = errors.New("Client回收时变成nil")
 	ErrPoolClosed       = errors.New("连接池已经被关闭")
 	ErrSocketDisconnect = errors.New("客户端socket连接已断开")
+
+	ErrEndpointUnavailable = errors.New("地址连续连接失败, 处于退避期")
+	ErrDraining            = errors.New("地址正在摘除流量")
 )
 
 func NewThriftPool(ip, port string,
 	maxConn, connTimeout, idleTimeout uint32,
 	dial ThriftDial, closeFunc ThriftClientClose) *ThriftPool {
 
+	thriftPool := NewEndpointPool(TCPEndpoint(ip, port), maxConn, connTimeout, idleTimeout, nil, closeFunc)
+	thriftPool.Dial = dial
+	return thriftPool
+}
+
+// 支持IPv6与unix socket地址的连接池; dial为nil时使用Dial字段, unix地址调用Dial时ip为socket路径, port为空
+func NewEndpointPool(e Endpoint,
+	maxConn, connTimeout, idleTimeout uint32,
+	dial EndpointDial, closeFunc ThriftClientClose) *ThriftPool {
+
+	ip, port := e.HostPort()
 	thriftPool := &ThriftPool{
-		Dial:        dial,
 		Close:       closeFunc,
 		ip:          ip,
 		port:        port,
+		endpoint:    e,
 		lock:        new(sync.Mutex),
 		maxConn:     maxConn,
 		idleTimeout: time.Duration(idleTimeout) * time.Second,
-		connTimeout: time.Duration(connTimeout) * time.Second,
 		closed:      false,
 		count:       0,
+		maxDialing:  MAXDIALING,
+		backoff:     DefaultDialBackoff,
+		notify:      make(chan struct{}),
+		weight:      1,
+		rampStart:   nowFunc(),
+		lastUsed:    nowFunc(),
+		stop:        make(chan struct{}),
 	}
+	thriftPool.DialEndpoint = dial
+	thriftPool.SetTimeouts(connTimeouts(connTimeout))
 
 	go thriftPool.ClearConn()
 
@@ -76,61 +202,344 @@ func NewThriftPool(ip, port string,
 }
 
 func (p *ThriftPool) Get() (*IdleClient, error) {
+	return p.GetMethod(context.Background(), "")
+}
+
+func (p *ThriftPool) GetContext(ctx context.Context) (*IdleClient, error) {
+	return p.GetMethod(ctx, "")
+}
+
+// method为即将调用的thrift方法名, 用于匹配方法级限流配置
+func (p *ThriftPool) GetMethod(ctx context.Context, method string) (*IdleClient, error) {
 	p.lock.Lock()
-	if p.closed {
-		p.lock.Unlock()
-		return nil, ErrPoolClosed
+	limiter, adaptive, maxConn := p.limiter, p.adaptive, p.maxConn
+	readTimeout, writeTimeout := p.readTimeout, p.writeTimeout
+	p.lastUsed = nowFunc()
+	p.lock.Unlock()
+
+	if limiter != nil {
+		if err := limiter.Wait(ctx, method); err != nil {
+			return nil, err
+		}
 	}
 
-	if p.idle.Len() == 0 && p.count >= p.maxConn {
-		p.lock.Unlock()
-		return nil, ErrOverMax
+	if adaptive != nil {
+		limit, inFlight, ok := adaptive.acquire(maxConn)
+		if !ok {
+			return nil, &OverloadError{Addr: p.addr(), Limit: limit, InFlight: inFlight}
+		}
 	}
 
-	if p.idle.Len() == 0 {
-		dial := p.Dial
-		p.count += 1
-		p.lock.Unlock()
-		client, err := dial(p.ip, p.port, p.connTimeout)
-		if err != nil {
-			p.lock.Lock()
-			if p.count > 0 {
-				p.count -= 1
+	client, err := p.get(ctx)
+	if err != nil {
+		if adaptive != nil {
+			adaptive.cancel()
+		}
+		return nil, err
+	}
+	client.SetTimeouts(readTimeout, writeTimeout)
+	client.pool = p
+	client.borrowed = nowFunc()
+	client.adaptive = adaptive
+	return client, nil
+}
+
+// 归还或关闭借出的client时记录本次借用的延迟与结果
+func (p *ThriftPool) returned(client *IdleClient, failed bool) {
+	if client.borrowed.IsZero() {
+		return
+	}
+	latency := nowFunc().Sub(client.borrowed)
+	client.borrowed = time.Time{}
+
+	p.lock.Lock()
+	maxConn, observer := p.maxConn, p.observer
+	p.lock.Unlock()
+
+	if client.adaptive != nil {
+		client.adaptive.release(latency, failed, maxConn)
+		client.adaptive = nil
+	}
+	if observer != nil {
+		observer(p, latency, failed)
+	}
+}
+
+func (p *ThriftPool) get(ctx context.Context) (*IdleClient, error) {
+	p.lock.Lock()
+	for {
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if p.draining {
+			p.lock.Unlock()
+			return nil, ErrDraining
+		}
+
+		if p.idle.Len() > 0 {
+			ele := p.idle.Front()
+			idlec := ele.Value.(*idleConn)
+			p.idle.Remove(ele)
+			p.lock.Unlock()
+
+			if !idlec.c.Check() {
+				p.lock.Lock()
+				p.decrCount()
+				p.lock.Unlock()
+				return nil, ErrSocketDisconnect
 			}
+			return idlec.c, nil
+		}
+
+		if p.unavailable != nil && nowFunc().Before(p.unavailable.RetryAt) {
+			err := p.unavailable
 			p.lock.Unlock()
 			return nil, err
 		}
-		if !client.Check() {
+
+		if p.count >= p.maxConn {
+			//Get直接拒绝; 可取消的ctx则等待连接归还或maxConn调大
+			if ctx.Done() == nil {
+				p.lock.Unlock()
+				return nil, ErrOverMax
+			}
+			notify := p.notify
+			p.lock.Unlock()
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 			p.lock.Lock()
-			if p.count > 0 {
-				p.count -= 1
+			continue
+		}
+
+		if p.dialing < p.maxDialing {
+			if p.budget == nil {
+				break
 			}
+			ok, notify := p.budget.acquire()
+			if ok {
+				break
+			}
+
+			//总连接数已满, 先回收其他地址的空闲连接
 			p.lock.Unlock()
-			return nil, ErrSocketDisconnect
+			if p.budget.reclaim(p) {
+				p.lock.Lock()
+				continue
+			}
+			if ctx.Done() == nil {
+				return nil, ErrTotalOverMax
+			}
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			p.lock.Lock()
+			continue
+		}
+
+		//Dial并发已满, 等待进行中的Dial结束; 若其失败则共享该错误, 不再重复Dial
+		notify, seq := p.notify, p.failSeq
+		p.lock.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		return client, nil
+		p.lock.Lock()
+		if p.failSeq != seq && p.idle.Len() == 0 {
+			err := p.dialErr
+			p.lock.Unlock()
+			return nil, err
+		}
+	}
+
+	dial, dialEndpoint, connTimeout := p.Dial, p.DialEndpoint, p.connTimeout
+	p.count += 1
+	p.dialing += 1
+	p.lock.Unlock()
+
+	var client *IdleClient
+	var err error
+	if dialEndpoint != nil {
+		client, err = dialEndpoint(p.endpoint, connTimeout)
 	} else {
-		ele := p.idle.Front()
-		idlec := ele.Value.(*idleConn)
-		p.idle.Remove(ele)
+		client, err = dial(p.ip, p.port, connTimeout)
+	}
+	if err == nil && !client.Check() {
+		err = ErrSocketDisconnect
+	}
+
+	p.lock.Lock()
+	p.dialing -= 1
+	if err != nil {
+		p.dialFailed(err)
+		p.decrCount()
 		p.lock.Unlock()
+		return nil, err
+	}
+	if p.unavailable != nil {
+		//从退避中恢复, 重新慢启动
+		p.rampStart = nowFunc()
+	}
+	p.dialFails = 0
+	p.unavailable = nil
+	p.broadcast()
+	p.lock.Unlock()
 
-		if !idlec.c.Check() {
-			p.lock.Lock()
-			if p.count > 0 {
-				p.count -= 1
-			}
-			p.lock.Unlock()
-			return nil, ErrSocketDisconnect
+	return client, nil
+}
+
+func (p *ThriftPool) addr() string {
+	return p.endpoint.String()
+}
+
+// 以下方法需持有p.lock
+func (p *ThriftPool) broadcast() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+func (p *ThriftPool) decrCount() {
+	if p.count > 0 {
+		p.count -= 1
+		if p.budget != nil {
+			p.budget.release(1)
 		}
-		return idlec.c, nil
 	}
+	if p.draining && p.count == 0 {
+		p.finishDrain()
+	}
+	p.broadcast()
+}
+
+func (p *ThriftPool) healthy() bool {
+	if p.closed {
+		return false
+	}
+	if p.unavailable != nil && nowFunc().Before(p.unavailable.RetryAt) {
+		return false
+	}
+	if nowFunc().Before(p.ejectedUntil) {
+		return false
+	}
+	if p.draining {
+		return false
+	}
+	return true
+}
+
+func (p *ThriftPool) inUse() uint32 {
+	n := uint32(p.idle.Len()) + p.dialing
+	if p.count < n {
+		return 0
+	}
+	return p.count - n
+}
+
+func (p *ThriftPool) dialFailed(err error) {
+	p.dialFails += 1
+	p.failSeq += 1
+	p.dialErr = err
+
+	if d := p.backoff.delay(p.dialFails); d > 0 {
+		p.unavailable = &UnavailableError{
+			Addr:    p.addr(),
+			RetryAt: nowFunc().Add(d),
+			Err:     err,
+		}
+	}
+}
+
+func (p *ThriftPool) SetMaxDialing(maxDialing uint32) {
+	if maxDialing == 0 {
+		maxDialing = 1
+	}
+	p.lock.Lock()
+	p.maxDialing = maxDialing
+	p.broadcast()
+	p.lock.Unlock()
+}
+
+// cfg.Rate<=0且未配置Methods时关闭限流
+func (p *ThriftPool) SetRateLimit(cfg RateLimit) {
+	var limiter *RateLimiter
+	if cfg.Rate > 0 || len(cfg.Methods) > 0 {
+		limiter = NewRateLimiter(cfg)
+	}
+	p.lock.Lock()
+	p.limiter = limiter
+	p.lock.Unlock()
+}
+
+// 调小时立即关闭多余的空闲连接, 借出中的连接在Put时关闭, 期间Get返回ErrOverMax;
+// 调大时唤醒等待中的GetContext
+func (p *ThriftPool) SetMaxConn(maxConn uint32) {
+	p.lock.Lock()
+	p.maxConn = maxConn
+	var surplus []*IdleClient
+	for p.count > p.maxConn && p.idle.Len() > 0 {
+		ele := p.idle.Back()
+		p.idle.Remove(ele)
+		surplus = append(surplus, ele.Value.(*idleConn).c)
+		p.decrCount()
+	}
+	p.broadcast()
+	p.lock.Unlock()
+
+	for _, c := range surplus {
+		p.Close(c)
+	}
+}
+
+// 单位秒, 调小后立即清理一次超时的空闲连接
+func (p *ThriftPool) SetIdleTimeout(idleTimeout uint32) {
+	p.lock.Lock()
+	p.idleTimeout = time.Duration(idleTimeout) * time.Second
+	p.lock.Unlock()
+
+	p.CheckTimeout()
+}
+
+// 单位秒, 同时设置建立连接与读写超时, 见SetTimeouts
+func (p *ThriftPool) SetConnTimeout(connTimeout uint32) {
+	p.SetTimeouts(connTimeouts(connTimeout))
+}
+
+// 只影响之后新建的连接, dialEndpoint非nil时优先使用
+func (p *ThriftPool) SetDial(dial ThriftDial, dialEndpoint EndpointDial) {
+	p.lock.Lock()
+	p.Dial, p.DialEndpoint = dial, dialEndpoint
+	p.lock.Unlock()
+}
+
+// 传入nil关闭自适应并发限制
+func (p *ThriftPool) SetAdaptiveLimiter(adaptive *AdaptiveLimiter) {
+	p.lock.Lock()
+	p.adaptive = adaptive
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) SetDialBackoff(backoff DialBackoff) {
+	p.lock.Lock()
+	p.backoff = backoff
+	if backoff.Threshold == 0 {
+		p.unavailable = nil
+	}
+	p.lock.Unlock()
 }
 
 func (p *ThriftPool) Put(client *IdleClient) error {
 	if client == nil {
 		return ErrInvalidConn
 	}
+	poisoned := client.Poisoned()
+	p.returned(client, poisoned)
 
 	p.lock.Lock()
 	if p.closed {
@@ -141,10 +550,18 @@ func (p *ThriftPool) Put(client *IdleClient) error {
 		return err
 	}
 
-	if p.count > p.maxConn {
-		if p.count > 0 {
-			p.count -= 1
-		}
+	if p.count > p.maxConn || p.draining {
+		p.decrCount()
+		p.lock.Unlock()
+
+		err := p.Close(client)
+		client = nil
+		return err
+	}
+
+	if poisoned {
+		p.poisoned += 1
+		p.decrCount()
 		p.lock.Unlock()
 
 		err := p.Close(client)
@@ -153,9 +570,7 @@ func (p *ThriftPool) Put(client *IdleClient) error {
 	}
 
 	if !client.Check() {
-		if p.count > 0 {
-			p.count -= 1
-		}
+		p.decrCount()
 		p.lock.Unlock()
 
 		err := p.Close(client)
@@ -163,12 +578,19 @@ func (p *ThriftPool) Put(client *IdleClient) error {
 		return err
 	}
 
+	//恢复本次借用中修改的超时
+	client.SetTimeouts(p.readTimeout, p.writeTimeout)
 	p.idle.PushBack(&idleConn{
 		c: client,
 		t: nowFunc(),
 	})
+	p.broadcast()
 	p.lock.Unlock()
 
+	if p.budget != nil {
+		p.budget.wake()
+	}
+
 	return nil
 }
 
@@ -176,11 +598,10 @@ func (p *ThriftPool) CloseErrConn(client *IdleClient) {
 	if client == nil {
 		return
 	}
+	p.returned(client, true)
 
 	p.lock.Lock()
-	if p.count > 0 {
-		p.count -= 1
-	}
+	p.decrCount()
 	p.lock.Unlock()
 
 	p.Close(client)
@@ -205,17 +626,22 @@ func (p *ThriftPool) CheckTimeout() {
 		p.lock.Unlock()
 		p.Close(v.c) //close client connection
 		p.lock.Lock()
-		if p.count > 0 {
-			p.count -= 1
-		}
+		p.decrCount()
 	}
 	p.lock.Unlock()
 
 	return
 }
 
+// 单位秒, 同时设置读写超时
 func (c *IdleClient) SetConnTimeout(connTimeout uint32) {
-	c.Socket.SetTimeout(time.Duration(connTimeout) * time.Second)
+	d := time.Duration(connTimeout) * time.Second
+	c.SetTimeouts(d, d)
+}
+
+// 借出该client的连接池
+func (c *IdleClient) Pool() *ThriftPool {
+	return c.pool
 }
 
 func (c *IdleClient) LocalAddr() net.Addr {
@@ -233,18 +659,118 @@ func (c *IdleClient) Check() bool {
 	return c.Socket.IsOpen()
 }
 
+func (p *ThriftPool) Endpoint() Endpoint {
+	return p.endpoint
+}
+
 func (p *ThriftPool) GetIdleCount() uint32 {
 	return uint32(p.idle.Len())
 }
 
+// 未关闭、不在Dial退避期内、未被异常检测摘除且未在摘除流量
+func (p *ThriftPool) Healthy() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.healthy()
+}
+
+// 已借出未归还的连接数
+func (p *ThriftPool) GetInUseCount() uint32 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.inUse()
+}
+
 func (p *ThriftPool) GetConnCount() uint32 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	return p.count
 }
 
+type PoolStats struct {
+	Addr        string
+	MaxConn     uint32
+	ConnCount   uint32
+	IdleCount   uint32
+	Dialing     uint32
+	InFlight    uint32
+	Limit       uint32 //自适应并发限制的当前值, 未开启时等于MaxConn
+	Unavailable bool
+
+	ConnTimeout  time.Duration //建立连接超时
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	MaxDialing   uint32
+	Rate         float64 //限流速率, 0表示不限流
+
+	Weight          uint32
+	EffectiveWeight float64 //慢启动期间按比例折算后的权重
+	Zone            string
+	Priority        int
+	Healthy         bool
+	EjectedUntil    time.Time //被异常检测摘除时的恢复时间
+	Draining        bool
+	LastUsed        time.Time //最近一次借用的时间
+	Poisoned        uint32    //调用未完整结束, 归还时关闭的连接数
+}
+
+func (p *ThriftPool) Stats() PoolStats {
+	p.lock.Lock()
+	stats := PoolStats{
+		Addr:        p.addr(),
+		MaxConn:     p.maxConn,
+		ConnCount:   p.count,
+		IdleCount:   uint32(p.idle.Len()),
+		Dialing:     p.dialing,
+		InFlight:    p.inUse(),
+		Limit:       p.maxConn,
+		Unavailable: p.unavailable != nil && nowFunc().Before(p.unavailable.RetryAt),
+
+		ConnTimeout:  p.connTimeout,
+		ReadTimeout:  p.readTimeout,
+		WriteTimeout: p.writeTimeout,
+		IdleTimeout:  p.idleTimeout,
+		MaxDialing:   p.maxDialing,
+
+		Weight:          p.weight,
+		EffectiveWeight: p.effectiveWeight(nowFunc()),
+		Zone:            p.zone,
+		Priority:        p.priority,
+		Healthy:         p.healthy(),
+		EjectedUntil:    p.ejectedUntil,
+		Draining:        p.draining,
+		LastUsed:        p.lastUsed,
+		Poisoned:        p.poisoned,
+	}
+	adaptive := p.adaptive
+	if p.limiter != nil && p.limiter.bucket != nil {
+		stats.Rate = p.limiter.bucket.rate
+	}
+	p.lock.Unlock()
+
+	if adaptive != nil {
+		stats.Limit = adaptive.Limit(stats.MaxConn)
+		stats.InFlight = adaptive.InFlight()
+	}
+	return stats
+}
+
+// 定期清理超时的空闲连接, Release时退出
 func (p *ThriftPool) ClearConn() {
+	p.lock.Lock()
+	stop := p.stop
+	p.lock.Unlock()
+
+	ticker := time.NewTicker(CHECKINTERVAL * time.Second)
+	defer ticker.Stop()
 	for {
 		p.CheckTimeout()
-		time.Sleep(CHECKINTERVAL * time.Second)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -252,8 +778,18 @@ func (p *ThriftPool) Release() {
 	p.lock.Lock()
 	idle := p.idle
 	p.idle.Init()
+	if !p.closed {
+		close(p.stop)
+	}
 	p.closed = true
+	if p.budget != nil {
+		p.budget.release(p.count)
+	}
 	p.count = 0
+	if p.draining {
+		p.finishDrain()
+	}
+	p.broadcast()
 	p.lock.Unlock()
 
 	for iter := idle.Front(); iter != nil; iter = iter.Next() {
@@ -265,89 +801,8 @@ func (p *ThriftPool) Recover() {
 	p.lock.Lock()
 	if p.closed == true {
 		p.closed = false
+		p.stop = make(chan struct{})
+		go p.ClearConn()
 	}
 	p.lock.Unlock()
 }
-
-type MapPool struct {
-	Dial  ThriftDial
-	Close ThriftClientClose
-
-	lock *sync.Mutex
-
-	idleTimeout uint32
-	connTimeout uint32
-	maxConn     uint32
-
-	pools map[string]*ThriftPool
-}
-
-func NewMapPool(maxConn, connTimeout, idleTimeout uint32,
-	dial ThriftDial, closeFunc ThriftClientClose) *MapPool {
-
-	return &MapPool{
-		Dial:        dial,
-		Close:       closeFunc,
-		maxConn:     maxConn,
-		idleTimeout: idleTimeout,
-		connTimeout: connTimeout,
-		pools:       make(map[string]*ThriftPool),
-		lock:        new(sync.Mutex),
-	}
-}
-
-func (mp *MapPool) getServerPool(ip, port string) (*ThriftPool, error) {
-	addr := fmt.Sprintf("%s:%s", ip, port)
-	mp.lock.Lock()
-	serverPool, ok := mp.pools[addr]
-	if !ok {
-		mp.lock.Unlock()
-		err := errors.New(fmt.Sprintf("Addr:%s thrift pool not exist", addr))
-		return nil, err
-	}
-	mp.lock.Unlock()
-	return serverPool, nil
-}
-
-func (mp *MapPool) Get(ip, port string) *ThriftPool {
-	serverPool, err := mp.getServerPool(ip, port)
-	if err != nil {
-		addr := fmt.Sprintf("%s:%s", ip, port)
-		serverPool = NewThriftPool(ip,
-			port,
-			mp.maxConn,
-			mp.connTimeout,
-			mp.idleTimeout,
-			mp.Dial,
-			mp.Close,
-		)
-		mp.lock.Lock()
-		mp.pools[addr] = serverPool
-		mp.lock.Unlock()
-	}
-	return serverPool
-}
-
-func (mp *MapPool) Release(ip, port string) error {
-	serverPool, err := mp.getServerPool(ip, port)
-	if err != nil {
-		return err
-	}
-
-	mp.lock.Lock()
-	delete(mp.pools, fmt.Sprintf("%s:%s", ip, port))
-	mp.lock.Unlock()
-
-	serverPool.Release()
-
-	return nil
-}
-
-func (mp *MapPool) ReleaseAll() {
-	mp.lock.Lock()
-	defer mp.lock.Unlock()
-
-	for _, serverPool := range mp.pools {
-		serverPool.Release()
-	}
-}
@@ -0,0 +1,663 @@
+package thriftPool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+func pipeDial(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+	conn, peer := net.Pipe()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := peer.Read(buf); err != nil {
+				peer.Close()
+				return
+			}
+		}
+	}()
+	return &IdleClient{
+		Socket: thrift.NewTSocketFromConnTimeout(conn, connTimeout),
+		Client: struct{}{},
+	}, nil
+}
+
+func pipeClose(c *IdleClient) error {
+	return c.Socket.Close()
+}
+
+func TestGetPut(t *testing.T) {
+	pool := NewThriftPool("127.0.0.1", "9999", 2, 1, 600, pipeDial, pipeClose)
+	defer pool.Release()
+
+	c1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	c2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	if _, err := pool.Get(); err != ErrOverMax {
+		t.Fatalf("expect ErrOverMax, got:%v", err)
+	}
+
+	pool.Put(c1)
+	pool.Put(c2)
+	if n := pool.GetIdleCount(); n != 2 {
+		t.Fatalf("idle count:%d is err", n)
+	}
+	if n := pool.GetConnCount(); n != 2 {
+		t.Fatalf("conn count:%d is err", n)
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	var dials int32
+	dialErr := errors.New("connection refused")
+	pool := NewThriftPool("127.0.0.1", "9999", 10, 1, 600,
+		func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, dialErr
+		}, pipeClose)
+	defer pool.Release()
+	pool.SetDialBackoff(DialBackoff{Threshold: 2, Base: time.Hour, Max: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.Get(); err != dialErr {
+			t.Fatalf("expect dial err, got:%v", err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := pool.Get()
+		if !errors.Is(err, ErrEndpointUnavailable) || !errors.Is(err, dialErr) {
+			t.Fatalf("expect unavailable err, got:%v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("dial count:%d is err", n)
+	}
+	if n := pool.GetConnCount(); n != 0 {
+		t.Fatalf("conn count:%d is err", n)
+	}
+}
+
+func TestMaxDialing(t *testing.T) {
+	var dialing, peak int32
+	release := make(chan struct{})
+	pool := NewThriftPool("127.0.0.1", "9999", 100, 1, 600,
+		func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+			n := atomic.AddInt32(&dialing, 1)
+			defer atomic.AddInt32(&dialing, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-release
+			return nil, errors.New("connection refused")
+		}, pipeClose)
+	defer pool.Release()
+	pool.SetMaxDialing(2)
+	pool.SetDialBackoff(DialBackoff{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.Get()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Fatalf("concurrent dial:%d over limit", p)
+	}
+	if n := pool.GetConnCount(); n != 0 {
+		t.Fatalf("conn count:%d is err", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	pool := NewThriftPool("127.0.0.1", "9999", 10, 1, 600, pipeDial, pipeClose)
+	defer pool.Release()
+	pool.SetRateLimit(RateLimit{
+		Rate:  0.001,
+		Burst: 2,
+		Mode:  LimitReject,
+		Methods: map[string]RateLimit{
+			"Sort": {Rate: 0.001, Burst: 1, Mode: LimitWait},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		c, err := pool.Get()
+		if err != nil {
+			t.Fatalf("get conn from pool err:%v", err)
+		}
+		pool.Put(c)
+	}
+	if _, err := pool.Get(); err != ErrRateLimited {
+		t.Fatalf("expect ErrRateLimited, got:%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c, err := pool.GetMethod(ctx, "Sort")
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	pool.Put(c)
+	if _, err := pool.GetMethod(ctx, "Sort"); err != ErrRateLimited {
+		t.Fatalf("expect ErrRateLimited, got:%v", err)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	pool := NewThriftPool("127.0.0.1", "9999", 10, 1, 600, pipeDial, pipeClose)
+	defer pool.Release()
+	pool.SetAdaptiveLimiter(NewAdaptiveLimiter(AdaptiveLimit{InitLimit: 2, MinLimit: 1}))
+
+	c1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	c2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	_, err = pool.Get()
+	var overload *OverloadError
+	if !errors.As(err, &overload) || overload.Limit != 2 || !errors.Is(err, ErrOverload) {
+		t.Fatalf("expect OverloadError, got:%v", err)
+	}
+	if s := pool.Stats(); s.Limit != 2 || s.InFlight != 2 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+
+	pool.CloseErrConn(c1)
+	pool.CloseErrConn(c2)
+	if s := pool.Stats(); s.Limit != 1 || s.InFlight != 0 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+}
+
+func TestSetMaxConn(t *testing.T) {
+	pool := NewThriftPool("127.0.0.1", "9999", 3, 1, 600, pipeDial, pipeClose)
+	defer pool.Release()
+
+	var clients []*IdleClient
+	for i := 0; i < 3; i++ {
+		c, err := pool.Get()
+		if err != nil {
+			t.Fatalf("get conn from pool err:%v", err)
+		}
+		clients = append(clients, c)
+	}
+	pool.Put(clients[0])
+	pool.Put(clients[1])
+
+	pool.SetMaxConn(1)
+	if n := pool.GetConnCount(); n != 1 {
+		t.Fatalf("conn count:%d is err", n)
+	}
+	if _, err := pool.Get(); err != ErrOverMax {
+		t.Fatalf("expect ErrOverMax, got:%v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c, err := pool.GetContext(ctx)
+		if err == nil {
+			pool.Put(c)
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pool.SetMaxConn(2)
+	if err := <-done; err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	pool.Put(clients[2])
+}
+
+func TestEndpointConfig(t *testing.T) {
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+
+	dialed := 0
+	mp.AddEndpoint("127.0.0.1", "9000")
+	big := mp.AddEndpointConfig("127.0.0.1", "9001", EndpointConfig{
+		MaxConn:     300,
+		ConnTimeout: 5,
+		RateLimit:   &RateLimit{Rate: 10},
+		Dial: func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+			dialed += 1
+			return pipeDial(ip, port, connTimeout)
+		},
+	})
+
+	mp.SetMaxConn(50)
+	s := mp.Stats()
+	if s["127.0.0.1:9000"].MaxConn != 50 || s["127.0.0.1:9000"].Rate != 0 {
+		t.Fatalf("default stats:%+v is err", s["127.0.0.1:9000"])
+	}
+	if s["127.0.0.1:9001"].MaxConn != 300 || s["127.0.0.1:9001"].ConnTimeout != 5*time.Second ||
+		s["127.0.0.1:9001"].IdleTimeout != 600*time.Second || s["127.0.0.1:9001"].Rate != 10 {
+		t.Fatalf("override stats:%+v is err", s["127.0.0.1:9001"])
+	}
+
+	c, err := big.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+	big.Put(c)
+	if dialed != 1 {
+		t.Fatalf("override dial called %d times", dialed)
+	}
+
+	//恢复默认配置
+	mp.SetEndpointConfig("127.0.0.1", "9001", EndpointConfig{})
+	if s := big.Stats(); s.MaxConn != 50 || s.ConnTimeout != time.Second || s.Rate != 0 {
+		t.Fatalf("reset stats:%+v is err", s)
+	}
+
+	//unix socket地址
+	e := UnixEndpoint("/var/run/rpc.sock")
+	unix := mp.RegisterEndpointConfig(e, EndpointConfig{MaxConn: 7})
+	if s := unix.Stats(); s.MaxConn != 7 || mp.EndpointConfigFor(e).MaxConn != 7 {
+		t.Fatalf("unix endpoint stats:%+v is err", s)
+	}
+	mp.SetEndpointConfigFor(e, EndpointConfig{MaxConn: 9})
+	if s := unix.Stats(); s.MaxConn != 9 {
+		t.Fatalf("unix endpoint stats after update:%+v is err", s)
+	}
+
+	//地址池已存在时, 独立的Dial用于之后新建的连接
+	existing := mp.Get("127.0.0.1", "9002")
+	c, _ = existing.Get()
+	mp.AddEndpointConfig("127.0.0.1", "9002", EndpointConfig{
+		Dial: func(ip, port string, connTimeout time.Duration) (*IdleClient, error) {
+			dialed += 1
+			return pipeDial(ip, port, connTimeout)
+		},
+	})
+	c2, err := existing.Get()
+	if err != nil {
+		t.Fatalf("get conn from existing pool err:%v", err)
+	}
+	existing.Put(c)
+	existing.Put(c2)
+	if dialed != 2 {
+		t.Fatalf("override dial on existing pool called %d times", dialed-1)
+	}
+}
+
+func TestMaxTotalConn(t *testing.T) {
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+	mp.SetMaxTotalConn(3)
+
+	a, b := mp.Get("127.0.0.1", "9000"), mp.Get("127.0.0.1", "9001")
+	a1, _ := a.Get()
+	a2, _ := a.Get()
+	a.Put(a1)
+	a.Put(a2)
+	b1, err := b.Get()
+	if err != nil {
+		t.Fatalf("get conn from pool err:%v", err)
+	}
+
+	//总数已满, 回收a的空闲连接
+	b2, err := b.Get()
+	if err != nil {
+		t.Fatalf("get conn with reclaim err:%v", err)
+	}
+	if n := mp.TotalConnCount(); n != 3 || a.GetIdleCount() != 1 {
+		t.Fatalf("total:%d a idle:%d is err", n, a.GetIdleCount())
+	}
+
+	a3, _ := a.Get()
+	if _, err := b.Get(); err != ErrTotalOverMax {
+		t.Fatalf("get conn over total err:%v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		a.Put(a3)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b3, err := b.GetContext(ctx)
+	if err != nil {
+		t.Fatalf("wait for reclaim err:%v", err)
+	}
+	b.Put(b1)
+	b.Put(b2)
+	b.Put(b3)
+
+	//调小时回收多余的空闲连接
+	mp.SetMaxTotalConn(1)
+	if n := mp.TotalConnCount(); n != 1 {
+		t.Fatalf("total after shrink:%d is err", n)
+	}
+	mp.Release("127.0.0.1", "9001")
+	if n := mp.TotalConnCount(); n != 0 {
+		t.Fatalf("total after release:%d is err", n)
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	cases := map[string]Endpoint{
+		"127.0.0.1:9000":       {Network: "tcp", Address: "127.0.0.1:9000"},
+		"[::1]:9000":           {Network: "tcp", Address: "[::1]:9000"},
+		"unix:///tmp/rpc.sock": {Network: "unix", Address: "/tmp/rpc.sock"},
+		"unix:/tmp/rpc.sock":   {Network: "unix", Address: "/tmp/rpc.sock"},
+		"rpc.service.local:80": {Network: "tcp", Address: "rpc.service.local:80"},
+	}
+	for s, want := range cases {
+		e, err := ParseEndpoint(s)
+		if err != nil || e != want {
+			t.Fatalf("parse %s:%+v err:%v", s, e, err)
+		}
+	}
+	if _, err := ParseEndpoint("::1:9000"); err == nil {
+		t.Fatalf("parse bare ipv6 should fail")
+	}
+	if e := TCPEndpoint("::1", "9000"); e.String() != "[::1]:9000" {
+		t.Fatalf("ipv6 endpoint:%s is err", e)
+	}
+}
+
+func TestEndpointPool(t *testing.T) {
+	mp := NewMapPool(100, 1, 600, pipeDial, pipeClose)
+	defer mp.ReleaseAll()
+
+	//并发创建同一地址只得到一个地址池
+	var wg sync.WaitGroup
+	pools := make([]*ThriftPool, 20)
+	for i := range pools {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pools[i] = mp.Get("::1", "9000")
+		}(i)
+	}
+	wg.Wait()
+	for _, p := range pools {
+		if p != pools[0] {
+			t.Fatalf("concurrent Get created multiple pools")
+		}
+	}
+	if _, ok := mp.Stats()["[::1]:9000"]; !ok || len(mp.Stats()) != 1 {
+		t.Fatalf("stats:%v is err", mp.Stats())
+	}
+
+	dir, err := ioutil.TempDir("", "thriftpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "rpc.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	mp.DialEndpoint = func(e Endpoint, connTimeout time.Duration) (*IdleClient, error) {
+		socket, err := e.DialSocket(connTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &IdleClient{Socket: socket, Client: struct{}{}}, nil
+	}
+	p := mp.GetEndpoint(UnixEndpoint(sock))
+	c, err := p.Get()
+	if err != nil {
+		t.Fatalf("get unix conn err:%v", err)
+	}
+	if c.RemoteAddr().Network() != "unix" {
+		t.Fatalf("remote addr:%v is err", c.RemoteAddr())
+	}
+	p.Put(c)
+	if s := p.Stats(); s.Addr != "unix://"+sock || s.IdleCount != 1 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+	if err := mp.ReleaseEndpoint(UnixEndpoint(sock)); err != nil {
+		t.Fatalf("release unix endpoint err:%v", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	e, _ := ParseEndpoint(l.Addr().String())
+	p := NewEndpointPool(e, 10, 3, 600, func(e Endpoint, connTimeout time.Duration) (*IdleClient, error) {
+		if connTimeout != 200*time.Millisecond {
+			t.Errorf("dial timeout:%v is err", connTimeout)
+		}
+		socket, err := e.DialSocket(connTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &IdleClient{Socket: socket, Client: struct{}{}}, nil
+	}, pipeClose)
+	defer p.Release()
+	p.SetTimeouts(Timeouts{Dial: 200 * time.Millisecond, Read: 5 * time.Second, Write: time.Second})
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, write := c.Timeouts(); read != 5*time.Second || write != time.Second {
+		t.Fatalf("client timeouts:%v %v is err", read, write)
+	}
+
+	//服务端不回复, 按本次借用的读超时返回
+	c.SetReadTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := c.Socket.Read(make([]byte, 1)); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("read err:%v after %v", err, time.Since(start))
+	}
+
+	if s := p.Stats(); s.ConnTimeout != 200*time.Millisecond || s.ReadTimeout != 5*time.Second || s.WriteTimeout != time.Second {
+		t.Fatalf("stats:%+v is err", s)
+	}
+	p.Put(c)
+	if read, write := c.Timeouts(); read != 5*time.Second || write != time.Second {
+		t.Fatalf("timeouts after put:%v %v is err", read, write)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	e, _ := ParseEndpoint(l.Addr().String())
+	p := NewEndpointPool(e, 10, 3, 600, func(e Endpoint, connTimeout time.Duration) (*IdleClient, error) {
+		socket, err := e.DialSocket(connTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &IdleClient{Socket: socket, Client: struct{}{}}, nil
+	}, pipeClose)
+	defer p.Release()
+	p.SetTimeouts(Timeouts{Dial: time.Second})
+
+	//正常完成的调用连接放回空闲队列
+	c, _ := p.Get()
+	if err := c.Call(context.Background(), func() error {
+		_, err := c.Socket.Write([]byte("ping"))
+		return err
+	}); err != nil {
+		t.Fatalf("call err:%v", err)
+	}
+	p.Put(c)
+	if s := p.Stats(); s.IdleCount != 1 {
+		t.Fatalf("stats:%+v is err", s)
+	}
+
+	//服务端不回复, 按ctx的deadline中断
+	c, _ = p.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = c.Call(ctx, func() error {
+		_, err := c.Socket.Read(make([]byte, 1))
+		return err
+	})
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatalf("call err:%v after %v", err, time.Since(start))
+	}
+	p.Put(c)
+	if s := p.Stats(); s.ConnCount != 0 || s.IdleCount != 0 {
+		t.Fatalf("interrupted conn should be closed, stats:%+v", s)
+	}
+
+	//没有deadline时取消ctx中断阻塞的读
+	c, _ = p.Get()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	if err := c.Call(ctx, func() error {
+		_, err := c.Socket.Read(make([]byte, 1))
+		return err
+	}); err != context.Canceled {
+		t.Fatalf("call err:%v", err)
+	}
+	p.Put(c)
+	if s := p.Stats(); s.ConnCount != 0 {
+		t.Fatalf("canceled conn should be closed, stats:%+v", s)
+	}
+
+	//读超时很长时取消同样立即中断
+	p.SetTimeouts(Timeouts{Dial: time.Second, Read: time.Minute, Write: time.Minute})
+	for i := 0; i < 20; i++ {
+		c, _ = p.Get()
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(time.Duration(i)*time.Millisecond, cancel)
+		start = time.Now()
+		if err := c.Call(ctx, func() error {
+			for {
+				if _, err := c.Socket.Read(make([]byte, 1)); err != nil {
+					return err
+				}
+			}
+		}); err != context.Canceled || time.Since(start) > 5*time.Second {
+			t.Fatalf("call err:%v after %v", err, time.Since(start))
+		}
+		p.Put(c)
+	}
+}
+
+func TestPoisonedConn(t *testing.T) {
+	pool := NewThriftPool("127.0.0.1", "9001", 10, 1, 600, pipeDial, pipeClose)
+	defer pool.Release()
+	var failed []bool
+	pool.observer = func(p *ThriftPool, latency time.Duration, f bool) {
+		failed = append(failed, f)
+	}
+
+	//协议读写用内存buffer代替socket, 写入的请求可以作为回复读回
+	borrow := func() (*IdleClient, thrift.TProtocol, *thrift.TMemoryBuffer) {
+		c, err := pool.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := thrift.NewTMemoryBuffer()
+		return c, c.TrackProtocol(thrift.NewTBinaryProtocolFactoryDefault()).GetProtocol(buf), buf
+	}
+	call := func(proto thrift.TProtocol, seqId int32) {
+		proto.WriteMessageBegin("Sort", thrift.CALL, seqId)
+		proto.WriteMessageEnd()
+		proto.Flush()
+	}
+
+	//完整的调用
+	c, proto, _ := borrow()
+	call(proto, 1)
+	proto.ReadMessageBegin()
+	proto.ReadMessageEnd()
+	pool.Put(c)
+	if s := pool.Stats(); s.IdleCount != 1 || s.Poisoned != 0 {
+		t.Fatalf("clean call stats:%+v is err", s)
+	}
+
+	//请求未Flush
+	c, proto, _ = borrow()
+	proto.WriteMessageBegin("Sort", thrift.CALL, 2)
+	pool.Put(c)
+
+	//回复未读
+	c, proto, _ = borrow()
+	call(proto, 3)
+	pool.Put(c)
+
+	//回复的seqid不一致
+	c, proto, buf := borrow()
+	thrift.NewTBinaryProtocolTransport(buf).WriteMessageBegin("Sort", thrift.REPLY, 9)
+	call(proto, 4)
+	proto.ReadMessageBegin()
+	proto.ReadMessageEnd()
+	pool.Put(c)
+
+	if s := pool.Stats(); s.ConnCount != 0 || s.Poisoned != 3 {
+		t.Fatalf("poisoned stats:%+v is err", s)
+	}
+	//未完整结束的调用按失败通知balancer与异常检测
+	if len(failed) != 4 || failed[0] || !failed[1] || !failed[2] || !failed[3] {
+		t.Fatalf("observed failures:%v is err", failed)
+	}
+}
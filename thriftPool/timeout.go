@@ -0,0 +1,211 @@
+package thriftPool
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// 建立连接、读、写三种超时, 0表示不超时
+type Timeouts struct {
+	Dial  time.Duration
+	Read  time.Duration
+	Write time.Duration
+}
+
+// connTimeout秒同时作为三种超时
+func connTimeouts(connTimeout uint32) Timeouts {
+	d := time.Duration(connTimeout) * time.Second
+	return Timeouts{Dial: d, Read: d, Write: d}
+}
+
+// 每次Read、Write前按各自的超时设置deadline, 设置了本次调用的deadline时取较早者
+type timeoutConn struct {
+	net.Conn
+	readTimeout  int64
+	writeTimeout int64
+	callDeadline int64 //UnixNano, 0表示没有
+}
+
+func (c *timeoutConn) deadline(timeout *int64) time.Time {
+	var d time.Time
+	if t := atomic.LoadInt64(timeout); t > 0 {
+		d = time.Now().Add(time.Duration(t))
+	}
+	if cd := atomic.LoadInt64(&c.callDeadline); cd != 0 && (d.IsZero() || cd < d.UnixNano()) {
+		d = time.Unix(0, cd)
+	}
+	return d
+}
+
+// interrupt设置的callDeadline, 已经过去的时间
+const interruptedDeadline = 1
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if d := c.deadline(&c.readTimeout); !d.IsZero() {
+		c.Conn.SetReadDeadline(d)
+		//与interrupt并发时可能覆盖了其设置的deadline, 重新检查
+		if atomic.LoadInt64(&c.callDeadline) == interruptedDeadline {
+			c.Conn.SetReadDeadline(time.Unix(0, interruptedDeadline))
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if d := c.deadline(&c.writeTimeout); !d.IsZero() {
+		c.Conn.SetWriteDeadline(d)
+		if atomic.LoadInt64(&c.callDeadline) == interruptedDeadline {
+			c.Conn.SetWriteDeadline(time.Unix(0, interruptedDeadline))
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// 读写超时可分别设置的TSocket, 由IdleClient.SetTimeouts与连接池设置;
+// thrift.TSocket只有一个超时, 直接使用时读写超时取两者中较大的值
+func NewTimeoutSocket(conn net.Conn) *thrift.TSocket {
+	return thrift.NewTSocketFromConnTimeout(&timeoutConn{Conn: conn}, 0)
+}
+
+// 设置本次借用的读写超时, Put时恢复为连接池的默认值
+func (c *IdleClient) SetTimeouts(read, write time.Duration) {
+	c.readTimeout, c.writeTimeout = read, write
+	if c.Socket == nil {
+		return
+	}
+	if tc, ok := c.Socket.Conn().(*timeoutConn); ok {
+		atomic.StoreInt64(&tc.readTimeout, int64(read))
+		atomic.StoreInt64(&tc.writeTimeout, int64(write))
+		return
+	}
+	if write > read {
+		read = write
+	}
+	c.Socket.SetTimeout(read)
+}
+
+func (c *IdleClient) SetReadTimeout(read time.Duration) {
+	c.SetTimeouts(read, c.writeTimeout)
+}
+
+func (c *IdleClient) SetWriteTimeout(write time.Duration) {
+	c.SetTimeouts(c.readTimeout, write)
+}
+
+func (c *IdleClient) Timeouts() (read, write time.Duration) {
+	return c.readTimeout, c.writeTimeout
+}
+
+// 在ctx的剩余时间内执行call, ctx结束时中断socket上阻塞的读写;
+// 被中断或超过deadline失败的调用读写位置已不确定, 连接在Put时关闭而不放回空闲队列, 此时返回ctx.Err()
+//
+//	err := c.Call(ctx, func() error {
+//		_, err := c.Client.(*tutorial.RpcServiceClient).Sort(list)
+//		return err
+//	})
+func (c *IdleClient) Call(ctx context.Context, call func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if ok {
+		c.setCallDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.interrupt()
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	err := call()
+	close(done)
+
+	if <-interrupted || (err != nil && ok && !time.Now().Before(deadline)) {
+		c.broken = true
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return context.DeadlineExceeded
+	}
+	if ok {
+		c.setCallDeadline(time.Time{})
+	}
+	return err
+}
+
+func (c *IdleClient) setCallDeadline(deadline time.Time) {
+	if tc, ok := c.Socket.Conn().(*timeoutConn); ok {
+		cd := int64(0)
+		if !deadline.IsZero() {
+			cd = deadline.UnixNano()
+		}
+		atomic.StoreInt64(&tc.callDeadline, cd)
+		return
+	}
+
+	//thrift.TSocket每次读写前按timeout重设deadline, 只能缩短timeout
+	c.SetTimeouts(c.readTimeout, c.writeTimeout)
+	if deadline.IsZero() {
+		return
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		timeout = time.Nanosecond
+	}
+	current := c.readTimeout
+	if c.writeTimeout > current {
+		current = c.writeTimeout
+	}
+	if current == 0 || timeout < current {
+		c.Socket.SetTimeout(timeout)
+	}
+}
+
+// 可能与call并发执行
+func (c *IdleClient) interrupt() {
+	conn := c.Socket.Conn()
+	if tc, ok := conn.(*timeoutConn); ok {
+		atomic.StoreInt64(&tc.callDeadline, interruptedDeadline)
+		tc.Conn.SetDeadline(time.Unix(0, interruptedDeadline))
+		return
+	}
+	//thrift.TSocket会重设deadline, 直接关闭连接
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// 对已存在及之后借出的连接生效, Dial只影响之后新建的连接
+func (p *ThriftPool) SetTimeouts(t Timeouts) {
+	p.lock.Lock()
+	p.connTimeout, p.readTimeout, p.writeTimeout = t.Dial, t.Read, t.Write
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) Timeouts() Timeouts {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return Timeouts{Dial: p.connTimeout, Read: p.readTimeout, Write: p.writeTimeout}
+}
+
+// 对已存在及之后创建的地址池生效, 设置了独立超时的地址保持其独立配置
+func (mp *MapPool) SetTimeouts(t Timeouts) {
+	mp.lock.Lock()
+	mp.timeouts = &t
+	configs := mp.poolConfigs()
+	mp.lock.Unlock()
+
+	for serverPool, cfg := range configs {
+		serverPool.SetTimeouts(*cfg.Timeouts)
+	}
+}
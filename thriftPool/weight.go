@@ -0,0 +1,84 @@
+package thriftPool
+
+import (
+	"time"
+)
+
+const (
+	SLOWSTARTMIN = 0.1 //慢启动开始时的权重比例
+)
+
+func (p *ThriftPool) SetWeight(weight uint32) {
+	p.lock.Lock()
+	p.weight = weight
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) Weight() uint32 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.weight
+}
+
+// 新加入或恢复的地址在window内权重从minFraction线性增长到完整权重, window为0关闭慢启动
+func (p *ThriftPool) SetSlowStart(window time.Duration, minFraction float64) {
+	if minFraction <= 0 || minFraction > 1 {
+		minFraction = SLOWSTARTMIN
+	}
+	p.lock.Lock()
+	p.slowStart = window
+	p.slowStartMin = minFraction
+	p.lock.Unlock()
+}
+
+// 重新开始慢启动
+func (p *ThriftPool) RestartSlowStart() {
+	p.restartSlowStartAt(nowFunc())
+}
+
+// start之前保持最小权重, 如摘除到期时才开始慢启动
+func (p *ThriftPool) restartSlowStartAt(start time.Time) {
+	p.lock.Lock()
+	p.rampStart = start
+	p.lock.Unlock()
+}
+
+func (p *ThriftPool) EffectiveWeight() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.effectiveWeight(nowFunc())
+}
+
+// 需持有p.lock
+func (p *ThriftPool) effectiveWeight(now time.Time) float64 {
+	weight := float64(p.weight)
+	if p.slowStart <= 0 {
+		return weight
+	}
+	elapsed := now.Sub(p.rampStart)
+	if elapsed >= p.slowStart {
+		return weight
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	//从slowStartMin线性增长到1
+	fraction := p.slowStartMin + (1-p.slowStartMin)*float64(elapsed)/float64(p.slowStart)
+	return weight * fraction
+}
+
+// 返回各地址的有效权重, 全部为0时按相同权重处理
+func effectiveWeights(pools []*ThriftPool) []float64 {
+	weights := make([]float64, len(pools))
+	total := 0.0
+	for i, p := range pools {
+		weights[i] = p.EffectiveWeight()
+		total += weights[i]
+	}
+	if total <= 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return weights
+}